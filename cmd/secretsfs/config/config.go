@@ -40,7 +40,57 @@ fio:
     templatespaths:
       default: /etc/secretsfs/templates/
       #applA: /appl/applA
+    # rendered templatefiles are cached per user for cachettl, 0 disables the
+    # cache
+    cachettl: 30s
+    # acls allow or deny access to templatespaths or single templates, e.g.
+    # - path: default/app.conf
+    #   allow:
+    #     users: [appl]
+    #     groups: [ops]
+    #     executables: [/usr/lib/jvm/*/bin/java]
+    #   deny:
+    #     users: [guest]
+    acls: []
+    # allowedpaths declare the store paths templates of a templatespath may
+    # reference, templatespaths without entry may reference all paths, e.g.
+    # default: [appl/, common/]
+    # "/" allows all paths
+    allowedpaths: {}
+    # environment variables of the secretsfs process templates may read with
+    # env, e.g. [HOSTNAME]. never allow variables containing credentials
+    allowedenv: []
+    # engines render templatefiles by their extension, others are rendered as
+    # go templates. available engines are go, consul and envsubst
+    engines:
+      ctmpl: consul
+      envsubst: envsubst
+    # consulprefixes are removed from paths in consul templates, e.g.
+    # [secret/data/, secret/]
+    consulprefixes: []
+    # serve the last valid output of templatefiles declaring a format, if the
+    # rendered output is invalid
+    lastknowngood: false
+    # last valid outputs are kept in memory for lastknowngoodttl
+    lastknowngoodttl: 1h
   secretsfiles:
+    # path mappings show additional directories in secretsfiles, which are
+    # resolved for the calling user. available placeholders are:
+    # {{.Username}}, {{.Uid}}, {{.Gid}} and {{.Groups}} (list of group names)
+    # names of mappings are always lowercase
+    pathmappings:
+      #"~": "users/{{.Username}}"
+    # hidden files rendering all keys of a secret at once, e.g. ".json" in every
+    # secret directory. Supported formats: json, yaml, env, properties
+    formats:
+      - json
+      - yaml
+      - env
+      - properties
+    # show a tar archive '<directory>.tar' of the whole subtree next to every
+    # directory. stat shows it with size 0, reading it reads every secret below
+    # the directory
+    tararchives: false
   internal:
     # privileges given to users or groups for listing and reading files in internal
     # do not make this readable for all, as it may contain critical data due to path namings
@@ -49,9 +99,105 @@ fio:
         - root
       groups:
         - admin
+  dynamic:
+    # mounts of vault secrets engines issuing dynamic secrets, e.g. database,
+    # aws or rabbitmq. credentials are issued from '<mount>/creds/<role>' and
+    # mapped to 'dynamic/<mount>/creds/<role>/'
+    mounts:
+      - database
+    # delay after the last release of an opened file, until its lease gets revoked
+    releasedelay: 10s
+  pki:
+    # mounts of vault pki secrets engines. certificates are issued from
+    # '<mount>/issue/<role>' and mapped to 'pki/<mount>/<role>/<common-name>/'
+    mounts:
+      - pki
+    # fraction of the certificate's lifetime, after which it gets reissued
+    renewfraction: 0.66
+    # requested ttl of issued certificates, defaults to the ttl of the role
+    #ttl: 72h
+  transit:
+    # mount of the vault transit secrets engine, its keys are mapped to
+    # 'transit/<key>/encrypt' and 'transit/<key>/decrypt'
+    mount: transit
+  projected:
+    # interval after which secrets in 'projected/' are read again. if any key
+    # changed, a new snapshot directory is created and '..data' swapped to it
+    refresh: 30s
+    # snapshots of secrets not accessed within this duration are dropped
+    expiry: 1h
+    # if a secret can't be read again, e.g. as vault is unreachable, its last
+    # snapshot is served for at most this duration since the last successful
+    # read. deleted secrets and secrets the user may not read anymore are
+    # dropped at once
+    maxstale: 5m
+  keystore:
+    # keys of a secret containing PEM encoded material, from which
+    # 'keystore/<path>/' assembles keystore.p12, keystore.jks and truststore.jks
+    keys:
+      # private key, certificate followed by its chain and CA certificates
+      key: tls.key
+      cert: tls.crt
+      ca: ca.crt
+      # password protecting the assembled keystores
+      password: keystore-password
+    # alias of the entries, defaults to the name of the secret
+    #alias: app
+  credentials:
+    # hosts rendered into 'credentials/netrc', 'credentials/git-credentials' and
+    # 'credentials/docker/config.json'. paths are resolved for the calling user
+    # with the placeholders of fio.secretsfiles.pathmappings
+    hosts:
+      #- host: github.com
+      #  path: "users/{{.Username}}/github"
+    # keys of the secrets containing username and password
+    usernamekey: username
+    passwordkey: password
+  totp:
+    # defaults for seeds, which don't define their own parameters in their
+    # otpauth:// URI or in the keys digits, period and algorithm of their secret
+    digits: 6
+    period: 30
+    algorithm: SHA1
+  overlay:
+    # views merge ordered lists of store paths, later paths override keys of
+    # earlier paths, e.g.
+    # prod-eu: [common, prod, prod/eu]
+    views: {}
+  passthrough:
+    # mirror directories, e.g. checked out configuration repositories
+    # the files in '/etc/secretsfs/config/' for example would be mapped to
+    # 'passthrough/config/'
+    paths: {}
+      #config: /etc/secretsfs/config/
+    # files matching pattern are rendered as templatefiles and shown without
+    # their extension
+    pattern: "*.tmpl"
+    # acls and allowedpaths of the rendered templates, like in templatefiles,
+    # paths without entry in allowedpaths may reference all secrets.
+    # paths of acls are paths below 'passthrough/', e.g. config/db.conf
+    acls: []
+    allowedpaths: {}
 
 store:
-  enabled: vault
+  # store to get the secrets from, see --print-stores for available stores
+  enabled: vault_kv
+  file:
+    # directory containing the secrets as plain files, used by the file store
+    # directories are mapped to paths, files to keys
+    # $HOME will be substituted with the calling user's home directory
+    # unix permissions of the calling user are checked on every access
+    path: "$HOME/.secretsfs/secrets"
+  layered:
+    # ordered list of stores queried by the layered store
+    # listings of all layers are merged, the first layer containing a key wins
+    # the file store additionally takes a path, defaults to store.file.path
+    layers:
+      #- store: file
+      #  path: "$HOME/.secretsfs/overrides"
+      - store: vault_kv
+      #- store: file
+      #  path: /var/lib/secretsfs/disaster-recovery
   vault:
     roleid:
       # path configuration defines, where to look for the vault roleid token
@@ -72,6 +218,16 @@ store:
     # defaults to a local dev instance
     addr: http://127.0.0.1:8200
 
+    # structuredkeys defines how keys with nested maps or lists as values are shown
+    # expand: nested maps are shown as directories, lists as directories with indexed files
+    # json:   such keys are shown as files '<key>.json' containing their JSON encoding
+    structuredkeys: expand
+
+    # keys with this suffix contain base64 encoded values, e.g. keystores or
+    # keytabs. they are shown without the suffix and with the decoded content
+    # an empty suffix disables decoding
+    base64suffix: ".b64"
+
     # vault TLS Configurations
     # for more information, see https://pkg.go.dev/github.com/hashicorp/vault/api#TLSConfig
     tls:
@@ -6,7 +6,9 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 
 	"github.com/hanwen/go-fuse/v2/fs"
 	"github.com/hanwen/go-fuse/v2/fuse"
@@ -132,9 +134,22 @@ func main() {
 	log.WithFields(log.Fields{"mountpoint": mountpoint}).Infof("%s mounted", os.Args[0])
 	log.Infof("Unmount by calling 'fusermount -u %s'", mountpoint)
 
+	// unmount on SIGINT and SIGTERM, so that FIOs may clean up afterwards
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		for sig := range sigs {
+			log.WithFields(log.Fields{"signal": sig}).Info("received signal, unmounting")
+			if err := server.Unmount(); err != nil {
+				log.WithFields(log.Fields{"error": err}).Error("error while unmounting")
+			}
+		}
+	}()
+
 	// Wait until unmount before exiting
 	log.Infof("Serving now...")
 	server.Wait()
+	sfs.ShutdownFIOs()
 }
 
 func usage() {
@@ -15,8 +15,12 @@ require (
 	github.com/muryoutaisuu/vaulthelper v1.1.3
 	github.com/oklog/run v1.1.0 // indirect
 	github.com/pierrec/lz4 v2.6.1+incompatible // indirect
+	github.com/pelletier/go-toml/v2 v2.0.5
 	github.com/postfinance/vaultkv v0.0.4
 	github.com/sirupsen/logrus v1.9.0
 	github.com/spf13/viper v1.14.0
+	golang.org/x/crypto v0.0.0-20220525230936-793ad666bf5e
 	gopkg.in/square/go-jose.v2 v2.6.0 // indirect
+	gopkg.in/ini.v1 v1.67.0
+	gopkg.in/yaml.v3 v3.0.1
 )
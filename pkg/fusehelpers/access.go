@@ -0,0 +1,101 @@
+package fusehelpers
+
+import (
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// OpenForUser opens the absolute path fpath on behalf of user u, as secretsfs
+// itself runs as root. Every component from / down to fpath is opened
+// relative to its parent without following symlinks. Every directory on the
+// way must be searchable by u and fpath itself must be readable by u, like
+// the kernel would check it for u. Only directories and regular files may be
+// opened.
+func OpenForUser(fpath string, u *user.User) (*os.File, error) {
+	fpath = filepath.Clean(fpath)
+	fd, err := syscall.Open(string(filepath.Separator), syscall.O_RDONLY|syscall.O_DIRECTORY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: fpath, Err: err}
+	}
+	for _, name := range strings.Split(fpath, string(filepath.Separator)) {
+		if name == "" {
+			continue
+		}
+		if err := checkAccess(fd, u, accessSearch); err != nil {
+			syscall.Close(fd)
+			return nil, &os.PathError{Op: "open", Path: fpath, Err: err}
+		}
+		// O_NONBLOCK, so that fifos don't block, they are rejected afterwards
+		next, err := syscall.Openat(fd, name, syscall.O_RDONLY|syscall.O_NOFOLLOW|syscall.O_NONBLOCK|syscall.O_CLOEXEC, 0)
+		syscall.Close(fd)
+		if err != nil {
+			return nil, &os.PathError{Op: "open", Path: fpath, Err: err}
+		}
+		fd = next
+	}
+	if err := checkAccess(fd, u, accessRead); err != nil {
+		syscall.Close(fd)
+		return nil, &os.PathError{Op: "open", Path: fpath, Err: err}
+	}
+	return os.NewFile(uintptr(fd), fpath), nil
+}
+
+// permission bits checked by checkAccess
+const (
+	accessRead   = 04
+	accessSearch = 01
+)
+
+// checkAccess checks whether user u has the permission perm on the file
+// opened as fd. Only directories may be searched, only directories and
+// regular files may be read.
+func checkAccess(fd int, u *user.User, perm uint32) error {
+	var st syscall.Stat_t
+	if err := syscall.Fstat(fd, &st); err != nil {
+		return err
+	}
+	switch st.Mode & syscall.S_IFMT {
+	case syscall.S_IFDIR:
+	case syscall.S_IFREG:
+		if perm == accessSearch {
+			return syscall.ENOTDIR
+		}
+	default:
+		return syscall.EACCES
+	}
+	if !mayAccess(&st, u, perm) {
+		return syscall.EACCES
+	}
+	return nil
+}
+
+// mayAccess checks the unix permissions of st for user u
+func mayAccess(st *syscall.Stat_t, u *user.User, perm uint32) bool {
+	if u.Uid == "0" {
+		return true
+	}
+	mode := st.Mode
+	if strconv.FormatUint(uint64(st.Uid), 10) == u.Uid {
+		return (mode>>6)&perm != 0
+	}
+	gids, err := u.GroupIds()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"username": u.Username,
+			"error":    err,
+			"calling":  "u.GroupIds()"}).Warn("got error while getting all usergroupids from user")
+	}
+	gid := strconv.FormatUint(uint64(st.Gid), 10)
+	for _, g := range append([]string{u.Gid}, gids...) {
+		if g == gid {
+			return (mode>>3)&perm != 0
+		}
+	}
+	return mode&perm != 0
+}
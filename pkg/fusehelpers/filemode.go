@@ -5,10 +5,10 @@ import (
 )
 
 const (
-	FILEREAD   = fuse.S_IFREG + 0x0755
-	FILENOREAD = fuse.S_IFREG + 0x0700
-	DIRREAD    = fuse.S_IFDIR + 0x0755
-	DIRNOREAD  = fuse.S_IFDIR + 0x0700
+	FILEREAD   = fuse.S_IFREG + 0755
+	FILENOREAD = fuse.S_IFREG + 0700
+	DIRREAD    = fuse.S_IFDIR + 0755
+	DIRNOREAD  = fuse.S_IFDIR + 0700
 )
 
 func IsFile(mode int64) bool {
@@ -18,3 +18,8 @@ func IsFile(mode int64) bool {
 func IsDir(mode int64) bool {
 	return fuse.S_IFDIR&mode == fuse.S_IFDIR
 }
+
+// Perm returns the permission bits of mode without its file type
+func Perm(mode int64) int64 {
+	return mode & 0777
+}
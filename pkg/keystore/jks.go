@@ -0,0 +1,160 @@
+package keystore
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+const (
+	jksMagic           = 0xfeedfeed
+	jksVersion         = 2
+	jksPrivateKeyEntry = 1
+	jksTrustedEntry    = 2
+)
+
+// oidJavaKeyProtector identifies the proprietary key protection of JKS
+var oidJavaKeyProtector = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 42, 2, 17, 1, 1}
+
+// jksWriter writes the entries of a JKS keystore
+type jksWriter struct {
+	buf bytes.Buffer
+}
+
+// EncodeJKS returns a JKS keystore containing key with its certificate chain
+// certs under alias. The first certificate must belong to key.
+func EncodeJKS(alias string, key interface{}, certs []*x509.Certificate, password string) ([]byte, error) {
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	protected, err := protectJKSKey(pkcs8, password)
+	if err != nil {
+		return nil, err
+	}
+
+	w := newJKSWriter(1)
+	w.writeUint32(jksPrivateKeyEntry)
+	w.writeEntryHeader(alias)
+	w.writeUint32(uint32(len(protected)))
+	w.buf.Write(protected)
+	w.writeUint32(uint32(len(certs)))
+	for _, cert := range certs {
+		w.writeCertificate(cert)
+	}
+	return w.finish(password), nil
+}
+
+// EncodeJKSTrustStore returns a JKS keystore containing certs as trusted
+// certificates. If there is more than one certificate, their aliases are
+// numbered, e.g. alias-0 and alias-1.
+func EncodeJKSTrustStore(alias string, certs []*x509.Certificate, password string) ([]byte, error) {
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("msg=\"no certificates given for truststore\"\n")
+	}
+	w := newJKSWriter(len(certs))
+	for i, cert := range certs {
+		a := alias
+		if len(certs) > 1 {
+			a = fmt.Sprintf("%s-%d", alias, i)
+		}
+		w.writeUint32(jksTrustedEntry)
+		w.writeEntryHeader(a)
+		w.writeCertificate(cert)
+	}
+	return w.finish(password), nil
+}
+
+func newJKSWriter(entries int) *jksWriter {
+	w := &jksWriter{}
+	w.writeUint32(jksMagic)
+	w.writeUint32(jksVersion)
+	w.writeUint32(uint32(entries))
+	return w
+}
+
+func (w *jksWriter) writeUint32(i uint32) {
+	binary.Write(&w.buf, binary.BigEndian, i)
+}
+
+// writeEntryHeader writes alias and creation date of an entry. JKS aliases
+// are case insensitive and always stored in lowercase.
+func (w *jksWriter) writeEntryHeader(alias string) {
+	w.writeUTF(strings.ToLower(alias))
+	binary.Write(&w.buf, binary.BigEndian, time.Now().UnixNano()/int64(time.Millisecond))
+}
+
+func (w *jksWriter) writeCertificate(cert *x509.Certificate) {
+	w.writeUTF("X.509")
+	w.writeUint32(uint32(len(cert.Raw)))
+	w.buf.Write(cert.Raw)
+}
+
+// writeUTF writes s in java's modified UTF-8 prefixed by its length
+func (w *jksWriter) writeUTF(s string) {
+	var b []byte
+	for _, c := range utf16.Encode([]rune(s)) {
+		switch {
+		case c >= 0x01 && c <= 0x7f:
+			b = append(b, byte(c))
+		case c <= 0x7ff:
+			b = append(b, byte(0xc0|c>>6), byte(0x80|c&0x3f))
+		default:
+			b = append(b, byte(0xe0|c>>12), byte(0x80|c>>6&0x3f), byte(0x80|c&0x3f))
+		}
+	}
+	binary.Write(&w.buf, binary.BigEndian, uint16(len(b)))
+	w.buf.Write(b)
+}
+
+// finish appends the keyed digest protecting the integrity of the keystore
+func (w *jksWriter) finish(password string) []byte {
+	h := sha1.New()
+	h.Write(jksPassword(password))
+	h.Write([]byte("Mighty Aphrodite"))
+	h.Write(w.buf.Bytes())
+	return h.Sum(w.buf.Bytes())
+}
+
+// protectJKSKey encrypts the PKCS#8 encoded key like
+// sun.security.provider.KeyProtector does
+func protectJKSKey(key []byte, password string) ([]byte, error) {
+	pw := jksPassword(password)
+	salt, err := randomBytes(sha1.Size)
+	if err != nil {
+		return nil, err
+	}
+
+	protected := append([]byte{}, salt...)
+	digest := salt
+	for i := 0; i < len(key); i += sha1.Size {
+		h := sha1.New()
+		h.Write(pw)
+		h.Write(digest)
+		digest = h.Sum(nil)
+		for j := 0; j < sha1.Size && i+j < len(key); j++ {
+			protected = append(protected, key[i+j]^digest[j])
+		}
+	}
+	h := sha1.New()
+	h.Write(pw)
+	h.Write(key)
+	protected = h.Sum(protected)
+
+	return asn1.Marshal(encryptedPrivateKeyInfo{
+		Algorithm:     algorithmIdentifier{Algorithm: oidJavaKeyProtector, Parameters: asn1.NullRawValue},
+		EncryptedData: protected,
+	})
+}
+
+// jksPassword returns password encoded as UTF-16BE without terminator
+func jksPassword(password string) []byte {
+	b := bmpString(password)
+	return b[:len(b)-2]
+}
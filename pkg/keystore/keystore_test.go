@@ -0,0 +1,344 @@
+package keystore
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+	"unicode/utf16"
+
+	"golang.org/x/crypto/pkcs12"
+)
+
+func TestPKCS12KDF(t *testing.T) {
+	salt := []byte{0x0a, 0x58, 0xcf, 0x64, 0x53, 0x0d, 0x82, 0x3f}
+	tables := []struct {
+		iterations int
+		want       string
+	}{
+		{1, "adabd656fdf48f697d004bab67f65555673852608f477f71"},
+		{1000, "21c58c406e8f4ffd23de1ce3467a1086b53f550e7001700f"},
+	}
+
+	for _, table := range tables {
+		got := hex.EncodeToString(pkcs12KDF(bmpString("sesame"), salt, table.iterations, 1, 24))
+		if got != table.want {
+			t.Errorf("derived key for %v iterations was incorrect, got: '%v', want: '%v'\n", table.iterations, got, table.want)
+		}
+	}
+}
+
+func TestParseAndEncode(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyder, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pemdata := append(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyder}),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+
+	parsedKey, err := ParsePrivateKey(pemdata)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certs, err := ParseCertificates(pemdata)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certs) != 1 || !bytes.Equal(certs[0].Raw, der) {
+		t.Errorf("parsed certificates were incorrect, got: '%v'\n", certs)
+	}
+	if _, err := ParseCertificates(keyder); err == nil {
+		t.Errorf("expected error for data without certificates\n")
+	}
+
+	p12, err := EncodePKCS12("app", parsedKey, certs, "changeit")
+	if err != nil {
+		t.Fatalf("got error while encoding PKCS#12: %v\n", err)
+	}
+	decodedKey, decodedCert, err := pkcs12.Decode(p12, "changeit")
+	if err != nil {
+		t.Fatalf("got error while decoding PKCS#12: %v\n", err)
+	}
+	if !key.Equal(decodedKey) {
+		t.Errorf("key of PKCS#12 was incorrect\n")
+	}
+	if !bytes.Equal(decodedCert.Raw, der) {
+		t.Errorf("certificate of PKCS#12 was incorrect\n")
+	}
+	if _, _, err := pkcs12.Decode(p12, "wrong"); err == nil {
+		t.Errorf("expected error for PKCS#12 with wrong password\n")
+	}
+
+	for _, table := range []struct {
+		name    string
+		encode  func() ([]byte, error)
+		entries []jksEntry
+	}{
+		{"keystore", func() ([]byte, error) { return EncodeJKS("App", parsedKey, certs, "changeit") }, []jksEntry{
+			{alias: "app", key: mustMarshalPKCS8(t, key), certs: [][]byte{der}},
+		}},
+		{"truststore", func() ([]byte, error) { return EncodeJKSTrustStore("ca", append(certs, certs...), "changeit") }, []jksEntry{
+			{alias: "ca-0", certs: [][]byte{der}},
+			{alias: "ca-1", certs: [][]byte{der}},
+		}},
+	} {
+		jks, err := table.encode()
+		if err != nil {
+			t.Errorf("got error while encoding %v: %v\n", table.name, err)
+			continue
+		}
+		entries, err := decodeJKS(jks, "changeit")
+		if err != nil {
+			t.Errorf("got error while decoding %v: %v\n", table.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(entries, table.entries) {
+			t.Errorf("entries of %v were incorrect, got: '%v', want: '%v'\n", table.name, entries, table.entries)
+		}
+		if _, err := decodeJKS(jks, "wrong"); err == nil {
+			t.Errorf("expected error for %v with wrong password\n", table.name)
+		}
+	}
+}
+
+func TestEncodePKCS12Chain(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var certs []*x509.Certificate
+	for _, cn := range []string{"example.com", "Example CA"} {
+		tmpl := &x509.Certificate{
+			SerialNumber: big.NewInt(1),
+			Subject:      pkix.Name{CommonName: cn},
+			NotBefore:    time.Now(),
+			NotAfter:     time.Now().Add(time.Hour),
+		}
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		certs = append(certs, cert)
+	}
+	p12, err := EncodePKCS12("app", key, certs, "changeit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocks, err := pkcs12.ToPEM(p12, "changeit")
+	if err != nil {
+		t.Fatalf("got error while decoding PKCS#12: %v\n", err)
+	}
+	var gotCerts [][]byte
+	var localKeyIDs []string
+	for _, b := range blocks {
+		if b.Type == "CERTIFICATE" {
+			gotCerts = append(gotCerts, b.Bytes)
+		}
+		if id, ok := b.Headers["localKeyId"]; ok {
+			localKeyIDs = append(localKeyIDs, id)
+		}
+		if name, ok := b.Headers["friendlyName"]; ok && name != "app" {
+			t.Errorf("friendly name was incorrect, got: '%v', want: '%v'\n", name, "app")
+		}
+	}
+	if len(gotCerts) != 2 || !bytes.Equal(gotCerts[0], certs[0].Raw) || !bytes.Equal(gotCerts[1], certs[1].Raw) {
+		t.Errorf("certificate chain of PKCS#12 was incorrect\n")
+	}
+	// the key and its certificate are linked by their local key id
+	if len(localKeyIDs) != 2 || localKeyIDs[0] != localKeyIDs[1] {
+		t.Errorf("local key ids were incorrect, got: '%v'\n", localKeyIDs)
+	}
+}
+
+// jksEntry is an entry decoded by decodeJKS
+type jksEntry struct {
+	alias string
+	// key is the PKCS#8 encoded key of private key entries
+	key   []byte
+	certs [][]byte
+}
+
+// decodeJKS decodes a JKS keystore independently of the encoder, following
+// sun.security.provider.JavaKeyStore and KeyProtector
+func decodeJKS(data []byte, password string) ([]jksEntry, error) {
+	var pw []byte
+	for _, c := range utf16.Encode([]rune(password)) {
+		pw = append(pw, byte(c>>8), byte(c))
+	}
+	if len(data) < sha1.Size {
+		return nil, errors.New("keystore too short")
+	}
+	body := data[:len(data)-sha1.Size]
+	h := sha1.New()
+	h.Write(pw)
+	h.Write([]byte("Mighty Aphrodite"))
+	h.Write(body)
+	if !bytes.Equal(h.Sum(nil), data[len(body):]) {
+		return nil, errors.New("keystore was tampered with, or password was incorrect")
+	}
+
+	r := bytes.NewReader(body)
+	var header struct{ Magic, Version, Count uint32 }
+	if err := binary.Read(r, binary.BigEndian, &header); err != nil {
+		return nil, err
+	}
+	if header.Magic != 0xfeedfeed || header.Version != 2 {
+		return nil, errors.New("invalid keystore format")
+	}
+	readBytes := func(n int) ([]byte, error) {
+		b := make([]byte, n)
+		_, err := io.ReadFull(r, b)
+		return b, err
+	}
+	readUint32 := func() (uint32, error) {
+		var i uint32
+		err := binary.Read(r, binary.BigEndian, &i)
+		return i, err
+	}
+	readUTF := func() (string, error) {
+		var n uint16
+		if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+			return "", err
+		}
+		b, err := readBytes(int(n))
+		return string(b), err
+	}
+	readCert := func() ([]byte, error) {
+		if certType, err := readUTF(); err != nil || certType != "X.509" {
+			return nil, fmt.Errorf("invalid certificate type '%v': %v", certType, err)
+		}
+		n, err := readUint32()
+		if err != nil {
+			return nil, err
+		}
+		return readBytes(int(n))
+	}
+
+	var entries []jksEntry
+	for i := uint32(0); i < header.Count; i++ {
+		tag, err := readUint32()
+		if err != nil {
+			return nil, err
+		}
+		var e jksEntry
+		if e.alias, err = readUTF(); err != nil {
+			return nil, err
+		}
+		if _, err := readBytes(8); err != nil {
+			return nil, err
+		}
+		switch tag {
+		case 1:
+			n, err := readUint32()
+			if err != nil {
+				return nil, err
+			}
+			protected, err := readBytes(int(n))
+			if err != nil {
+				return nil, err
+			}
+			if e.key, err = recoverJKSKey(protected, pw); err != nil {
+				return nil, err
+			}
+			count, err := readUint32()
+			if err != nil {
+				return nil, err
+			}
+			for j := uint32(0); j < count; j++ {
+				cert, err := readCert()
+				if err != nil {
+					return nil, err
+				}
+				e.certs = append(e.certs, cert)
+			}
+		case 2:
+			cert, err := readCert()
+			if err != nil {
+				return nil, err
+			}
+			e.certs = [][]byte{cert}
+		default:
+			return nil, fmt.Errorf("invalid entry tag '%v'", tag)
+		}
+		entries = append(entries, e)
+	}
+	if r.Len() != 0 {
+		return nil, errors.New("trailing data after entries")
+	}
+	return entries, nil
+}
+
+// recoverJKSKey reverses the proprietary key protection of JKS
+func recoverJKSKey(protected, pw []byte) ([]byte, error) {
+	var info struct {
+		Algorithm struct {
+			Algorithm  asn1.ObjectIdentifier
+			Parameters asn1.RawValue `asn1:"optional"`
+		}
+		EncryptedData []byte
+	}
+	if _, err := asn1.Unmarshal(protected, &info); err != nil {
+		return nil, err
+	}
+	if !info.Algorithm.Algorithm.Equal(asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 42, 2, 17, 1, 1}) {
+		return nil, fmt.Errorf("unsupported key protection '%v'", info.Algorithm.Algorithm)
+	}
+	data := info.EncryptedData
+	if len(data) < 2*sha1.Size {
+		return nil, errors.New("protected key too short")
+	}
+	salt, encrypted, check := data[:sha1.Size], data[sha1.Size:len(data)-sha1.Size], data[len(data)-sha1.Size:]
+	key := make([]byte, len(encrypted))
+	digest := salt
+	for i := range encrypted {
+		if i%sha1.Size == 0 {
+			sum := sha1.Sum(append(append([]byte{}, pw...), digest...))
+			digest = sum[:]
+		}
+		key[i] = encrypted[i] ^ digest[i%sha1.Size]
+	}
+	if sum := sha1.Sum(append(append([]byte{}, pw...), key...)); !bytes.Equal(sum[:], check) {
+		return nil, errors.New("integrity check of protected key failed")
+	}
+	return key, nil
+}
+
+func mustMarshalPKCS8(t *testing.T, key interface{}) []byte {
+	b, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
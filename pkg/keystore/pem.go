@@ -0,0 +1,44 @@
+// keystore assembles java keystores from PEM encoded keys and certificates.
+package keystore
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+)
+
+// ParsePrivateKey returns the first private key found in the PEM encoded
+// data. PKCS#1, PKCS#8 and EC private keys are supported.
+func ParsePrivateKey(data []byte) (interface{}, error) {
+	for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+		switch block.Type {
+		case "RSA PRIVATE KEY":
+			return x509.ParsePKCS1PrivateKey(block.Bytes)
+		case "EC PRIVATE KEY":
+			return x509.ParseECPrivateKey(block.Bytes)
+		case "PRIVATE KEY":
+			return x509.ParsePKCS8PrivateKey(block.Bytes)
+		}
+	}
+	return nil, fmt.Errorf("msg=\"no private key found in PEM data\"\n")
+}
+
+// ParseCertificates returns all certificates found in the PEM encoded data in
+// their order.
+func ParseCertificates(data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("msg=\"no certificate found in PEM data\"\n")
+	}
+	return certs, nil
+}
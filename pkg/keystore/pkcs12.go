@@ -0,0 +1,308 @@
+package keystore
+
+import (
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/asn1"
+	"math/big"
+	"unicode/utf16"
+)
+
+// iterations used for key derivation in PKCS#12 files, as written by keytool
+const pkcs12Iterations = 2048
+
+var (
+	oidData                  = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidShroudedKeyBag        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 2}
+	oidCertBag               = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 3}
+	oidCertTypeX509          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 22, 1}
+	oidFriendlyName          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 20}
+	oidLocalKeyID            = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 21}
+	oidPBEWithSHAAnd3KeyTDES = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1, 3}
+	oidSHA1                  = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+)
+
+type pfx struct {
+	Version  int
+	AuthSafe contentInfo
+	MacData  macData
+}
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue
+}
+
+type macData struct {
+	Mac        digestInfo
+	MacSalt    []byte
+	Iterations int
+}
+
+type digestInfo struct {
+	Algorithm algorithmIdentifier
+	Digest    []byte
+}
+
+type algorithmIdentifier struct {
+	Algorithm  asn1.ObjectIdentifier
+	Parameters asn1.RawValue `asn1:"optional"`
+}
+
+type pbeParams struct {
+	Salt       []byte
+	Iterations int
+}
+
+type encryptedPrivateKeyInfo struct {
+	Algorithm     algorithmIdentifier
+	EncryptedData []byte
+}
+
+type safeBag struct {
+	ID         asn1.ObjectIdentifier
+	Value      asn1.RawValue
+	Attributes []attribute `asn1:"set,optional"`
+}
+
+type attribute struct {
+	ID    asn1.ObjectIdentifier
+	Value asn1.RawValue
+}
+
+type certBag struct {
+	ID   asn1.ObjectIdentifier
+	Data []byte `asn1:"tag:0,explicit"`
+}
+
+// EncodePKCS12 returns a PKCS#12 keystore containing key with its certificate
+// chain certs under alias. The first certificate must belong to key.
+// Keys are encrypted with pbeWithSHAAnd3-KeyTripleDES-CBC and the file is
+// protected with a HMAC-SHA1, which all java versions are able to read.
+func EncodePKCS12(alias string, key interface{}, certs []*x509.Certificate, password string) ([]byte, error) {
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	pw := bmpString(password)
+	localKeyID := sha1.Sum(certs[0].Raw)
+
+	keyAttributes, err := bagAttributes(alias, localKeyID[:])
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err := encryptPBE(pkcs8, pw)
+	if err != nil {
+		return nil, err
+	}
+	keyBag, err := newSafeBag(oidShroudedKeyBag, encrypted, keyAttributes)
+	if err != nil {
+		return nil, err
+	}
+
+	var certBags []safeBag
+	for i, cert := range certs {
+		var attributes []attribute
+		if i == 0 {
+			attributes = keyAttributes
+		}
+		bag, err := newSafeBag(oidCertBag, certBag{ID: oidCertTypeX509, Data: cert.Raw}, attributes)
+		if err != nil {
+			return nil, err
+		}
+		certBags = append(certBags, bag)
+	}
+
+	var authSafe []contentInfo
+	for _, bags := range [][]safeBag{certBags, {keyBag}} {
+		ci, err := newDataContentInfo(bags)
+		if err != nil {
+			return nil, err
+		}
+		authSafe = append(authSafe, ci)
+	}
+	authSafeBytes, err := asn1.Marshal(authSafe)
+	if err != nil {
+		return nil, err
+	}
+
+	salt, err := randomBytes(20)
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha1.New, pkcs12KDF(pw, salt, pkcs12Iterations, 3, 20))
+	mac.Write(authSafeBytes)
+
+	authSafeContent, err := asn1.Marshal(authSafeBytes)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(pfx{
+		Version: 3,
+		AuthSafe: contentInfo{
+			ContentType: oidData,
+			Content:     explicitTag(authSafeContent),
+		},
+		MacData: macData{
+			Mac: digestInfo{
+				Algorithm: algorithmIdentifier{Algorithm: oidSHA1, Parameters: asn1.NullRawValue},
+				Digest:    mac.Sum(nil),
+			},
+			MacSalt:    salt,
+			Iterations: pkcs12Iterations,
+		},
+	})
+}
+
+// newSafeBag returns a SafeBag of type id containing the DER encoding of value
+func newSafeBag(id asn1.ObjectIdentifier, value interface{}, attributes []attribute) (safeBag, error) {
+	b, err := asn1.Marshal(value)
+	if err != nil {
+		return safeBag{}, err
+	}
+	return safeBag{ID: id, Value: explicitTag(b), Attributes: attributes}, nil
+}
+
+// newDataContentInfo returns an unencrypted ContentInfo containing bags
+func newDataContentInfo(bags []safeBag) (contentInfo, error) {
+	b, err := asn1.Marshal(bags)
+	if err != nil {
+		return contentInfo{}, err
+	}
+	content, err := asn1.Marshal(b)
+	if err != nil {
+		return contentInfo{}, err
+	}
+	return contentInfo{ContentType: oidData, Content: explicitTag(content)}, nil
+}
+
+// explicitTag wraps the DER encoded value b in an explicit [0] tag
+func explicitTag(b []byte) asn1.RawValue {
+	return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: b}
+}
+
+// bagAttributes returns the attributes linking key and certificate, alias is
+// used by java as name of the entry
+func bagAttributes(alias string, localKeyID []byte) ([]attribute, error) {
+	id, err := asn1.Marshal(localKeyID)
+	if err != nil {
+		return nil, err
+	}
+	name := asn1.RawValue{Tag: asn1.TagBMPString, Bytes: bmpString(alias)}
+	name.Bytes = name.Bytes[:len(name.Bytes)-2]
+	friendlyName, err := asn1.Marshal(name)
+	if err != nil {
+		return nil, err
+	}
+	return []attribute{
+		{ID: oidFriendlyName, Value: asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: friendlyName}},
+		{ID: oidLocalKeyID, Value: asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: id}},
+	}, nil
+}
+
+// encryptPBE encrypts data with pbeWithSHAAnd3-KeyTripleDES-CBC
+func encryptPBE(data, password []byte) (encryptedPrivateKeyInfo, error) {
+	salt, err := randomBytes(8)
+	if err != nil {
+		return encryptedPrivateKeyInfo{}, err
+	}
+	params, err := asn1.Marshal(pbeParams{Salt: salt, Iterations: pkcs12Iterations})
+	if err != nil {
+		return encryptedPrivateKeyInfo{}, err
+	}
+	block, err := des.NewTripleDESCipher(pkcs12KDF(password, salt, pkcs12Iterations, 1, 24))
+	if err != nil {
+		return encryptedPrivateKeyInfo{}, err
+	}
+	iv := pkcs12KDF(password, salt, pkcs12Iterations, 2, block.BlockSize())
+
+	padding := block.BlockSize() - len(data)%block.BlockSize()
+	encrypted := make([]byte, len(data), len(data)+padding)
+	copy(encrypted, data)
+	for i := 0; i < padding; i++ {
+		encrypted = append(encrypted, byte(padding))
+	}
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(encrypted, encrypted)
+
+	return encryptedPrivateKeyInfo{
+		Algorithm:     algorithmIdentifier{Algorithm: oidPBEWithSHAAnd3KeyTDES, Parameters: asn1.RawValue{FullBytes: params}},
+		EncryptedData: encrypted,
+	}, nil
+}
+
+// pkcs12KDF derives size bytes of key material for purpose id from password
+// and salt with SHA-1, see RFC 7292 appendix B.2
+func pkcs12KDF(password, salt []byte, iterations int, id byte, size int) []byte {
+	const v = 64
+
+	d := make([]byte, v)
+	for i := range d {
+		d[i] = id
+	}
+	fill := func(b []byte) []byte {
+		out := make([]byte, v*((len(b)+v-1)/v))
+		for i := range out {
+			out[i] = b[i%len(b)]
+		}
+		return out
+	}
+	var i []byte
+	if len(salt) > 0 {
+		i = append(i, fill(salt)...)
+	}
+	if len(password) > 0 {
+		i = append(i, fill(password)...)
+	}
+
+	one := big.NewInt(1)
+	var out []byte
+	for len(out) < size {
+		h := sha1.New()
+		h.Write(d)
+		h.Write(i)
+		a := h.Sum(nil)
+		for r := 1; r < iterations; r++ {
+			sum := sha1.Sum(a)
+			a = sum[:]
+		}
+		out = append(out, a...)
+
+		// I_j = (I_j + B + 1) mod 2^(v*8) for every block of I
+		b := new(big.Int).SetBytes(fill(a)[:v])
+		b.Add(b, one)
+		for j := 0; j < len(i); j += v {
+			ij := new(big.Int).SetBytes(i[j : j+v])
+			ij.Add(ij, b)
+			ijb := ij.Bytes()
+			if len(ijb) > v {
+				ijb = ijb[len(ijb)-v:]
+			}
+			for k := 0; k < v; k++ {
+				i[j+k] = 0
+			}
+			copy(i[j+v-len(ijb):j+v], ijb)
+		}
+	}
+	return out[:size]
+}
+
+// bmpString returns s encoded as null terminated UTF-16BE, as used for
+// passwords in PKCS#12
+func bmpString(s string) []byte {
+	units := utf16.Encode([]rune(s))
+	b := make([]byte, 0, 2*len(units)+2)
+	for _, c := range units {
+		b = append(b, byte(c>>8), byte(c))
+	}
+	return append(b, 0, 0)
+}
+
+func randomBytes(n int) ([]byte, error) {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	return b, err
+}
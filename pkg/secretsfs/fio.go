@@ -34,6 +34,42 @@ type FIORoot interface {
 	FIOPath() string
 }
 
+// FIOReleaser may be implemented additionally by FIO plugins, which need to
+// know when a FileHandle returned by Open is released again.
+type FIOReleaser interface {
+	Release(n *SfsNode, ctx context.Context, f fs.FileHandle) syscall.Errno
+}
+
+// FIOWriter may be implemented additionally by FIO plugins, that accept
+// writes into their files.
+type FIOWriter interface {
+	Write(n *SfsNode, ctx context.Context, f fs.FileHandle, data []byte, off int64) (written uint32, errno syscall.Errno)
+}
+
+// FIOSetattrer may be implemented additionally by FIO plugins, that allow
+// changing attributes of their files, e.g. truncating them.
+type FIOSetattrer interface {
+	Setattr(n *SfsNode, ctx context.Context, f fs.FileHandle, in *fuse.SetAttrIn, out *fuse.AttrOut) syscall.Errno
+}
+
+// FIOReadlinker may be implemented additionally by FIO plugins, that show
+// symlinks.
+type FIOReadlinker interface {
+	Readlink(n *SfsNode, ctx context.Context) ([]byte, syscall.Errno)
+}
+
+// FIOXattrer may be implemented additionally by FIO plugins, that show
+// extended attributes on their files.
+type FIOXattrer interface {
+	Xattrs(n *SfsNode, ctx context.Context) (map[string][]byte, syscall.Errno)
+}
+
+// FIOShutdowner may be implemented additionally by FIO plugins, which need to
+// clean up before secretsfs exits.
+type FIOShutdowner interface {
+	Shutdown()
+}
+
 // FIOMap maps the FIORoot Node to a Mountpath
 // Used for registering FIORoots to the secretsfs rootnode
 type FIOMap struct {
@@ -107,6 +143,17 @@ func FIOMapsEnabled() map[string]*FIOMap {
 	return enabledFIOMaps
 }
 
+// ShutdownFIOs calls Shutdown on all registered FIO plugins implementing
+// FIOShutdowner
+func ShutdownFIOs() {
+	for k, fm := range fiomaps {
+		if sd, ok := fm.Root.(FIOShutdowner); ok {
+			log.WithFields(log.Fields{"fio": k}).Debug("shutting down FIO")
+			sd.Shutdown()
+		}
+	}
+}
+
 func loadEnabledFIOs(init bool) {
 	enabledFIOs = viper.GetStringSlice("fio.enabled")
 	// if not from init, then reload all fiomaps
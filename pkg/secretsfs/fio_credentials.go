@@ -0,0 +1,251 @@
+package secretsfs
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+
+	"github.com/muryoutaisuu/secretsfs/pkg/store"
+)
+
+// FIOCredentials renders the credentials of the hosts configured in
+// fio.credentials.hosts into the files of common credential helpers.
+// Following directory structure is implemented:
+//   	credentials/
+//   	├── docker
+//   	│   └── config.json
+//   	├── git-credentials
+//   	└── netrc
+// The paths of the hosts are resolved for the calling user, hosts whose
+// secret can't be read by the user are left out.
+type FIOCredentials struct{}
+
+// credentialsHost is an entry of fio.credentials.hosts
+type credentialsHost struct {
+	// Host is the name of the host, optionally including a port
+	Host string
+	// Path is a path template of the secret containing the credentials, see
+	// renderPathTemplate
+	Path string
+}
+
+// credential contains the login of the calling user on a host
+type credential struct {
+	host     string
+	username string
+	password string
+}
+
+// credentialsFiles maps the files of FIOCredentials to their renderers
+var credentialsFiles = map[string]func(creds []credential) ([]byte, error){
+	"netrc":              renderNetrc,
+	"git-credentials":    renderGitCredentials,
+	"docker/config.json": renderDockerConfig,
+}
+
+var _ = (FIORoot)((*FIOCredentials)(nil))
+
+func (sf *FIOCredentials) Readdir(n *SfsNode, ctx context.Context) (out fs.DirStream, errno syscall.Errno) {
+	log.WithFields(log.Fields{"n": n, "n.npath": n.npath}).Debug("log values")
+
+	_, secpath := rootName(n.npath)
+	var direntries []fuse.DirEntry
+	dirs := make(map[string]bool)
+	for name := range credentialsFiles {
+		dir, file := parentPath(name), filepath.Base(name)
+		switch {
+		case dir == secpath:
+			direntries = append(direntries, getDirEntry(n.npath, file, fuse.S_IFREG))
+		case parentPath(dir) == secpath && !dirs[dir]:
+			dirs[dir] = true
+			direntries = append(direntries, getDirEntry(n.npath, filepath.Base(dir), fuse.S_IFDIR))
+		}
+	}
+	if len(direntries) == 0 {
+		return nil, syscall.ENOENT
+	}
+	log.WithFields(log.Fields{"direntries": direntries}).Debug("log values")
+	return fs.NewListDirStream(direntries), fs.OK
+}
+
+func (sf *FIOCredentials) Lookup(n *SfsNode, ctx context.Context, name string, out *fuse.EntryOut) (node *fs.Inode, errno syscall.Errno) {
+	log.WithFields(log.Fields{
+		"n":          n,
+		"n.npath":    n.npath,
+		"name":       name,
+		"out.NodeId": out.NodeId}).Debug("log values")
+
+	prefixedfullname := filepath.Join(n.npath, name)
+	_, secpath := rootName(prefixedfullname)
+	if _, ok := credentialsFiles[secpath]; ok {
+		return getLookupChild(n, prefixedfullname, fuse.S_IFREG, ctx, out)
+	}
+	for f := range credentialsFiles {
+		if strings.HasPrefix(f, secpath+"/") {
+			return getLookupChild(n, prefixedfullname, fuse.S_IFDIR, ctx, out)
+		}
+	}
+	return nil, syscall.ENOENT
+}
+
+func (sf *FIOCredentials) Open(n *SfsNode, ctx context.Context, flags uint32) (fh fs.FileHandle, fuseFlags uint32, errno syscall.Errno) {
+	log.WithFields(log.Fields{"n": n, "n.npath": n.npath}).Debug("log values")
+	content, errno := renderCredentialsFile(n.npath, ctx)
+	if errno != fs.OK {
+		return nil, 0, errno
+	}
+	// content depends on the calling user, so don't let the kernel cache it
+	return &contentHandle{content: content}, fuse.FOPEN_DIRECT_IO, fs.OK
+}
+
+func (sf *FIOCredentials) Read(n *SfsNode, ctx context.Context, f fs.FileHandle, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
+	log.WithFields(log.Fields{"n": n, "n.npath": n.npath}).Debug("log values")
+	if h, ok := f.(*contentHandle); ok {
+		return readResultAt(h.content, dest, off), fs.OK
+	}
+	content, errno := renderCredentialsFile(n.npath, ctx)
+	if errno != fs.OK {
+		return nil, errno
+	}
+	return readResultAt(content, dest, off), fs.OK
+}
+
+func (sf *FIOCredentials) Getattr(n *SfsNode, ctx context.Context, fh fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	log.WithFields(log.Fields{"n": n, "n.npath": n.npath}).Debug("log values")
+	_, secpath := rootName(n.npath)
+	if _, ok := credentialsFiles[secpath]; ok {
+		content, errno := renderCredentialsFile(n.npath, ctx)
+		if errno != fs.OK {
+			return errno
+		}
+		out.Size = uint64(len(content))
+	}
+	out.Ino = GetInode(n.npath)
+	return fs.OK
+}
+
+func (sf *FIOCredentials) FIOPath() string {
+	return "credentials"
+}
+
+// renderCredentialsFile renders the file at npath for the calling user
+func renderCredentialsFile(npath string, ctx context.Context) ([]byte, syscall.Errno) {
+	_, secpath := rootName(npath)
+	render, ok := credentialsFiles[secpath]
+	if !ok {
+		return nil, syscall.EISDIR
+	}
+	creds, err := getCredentials(ctx)
+	if err != nil {
+		log.WithFields(log.Fields{"secpath": secpath, "error": err}).Error("got error while getting credentials")
+		return nil, syscall.EACCES
+	}
+	content, err := render(creds)
+	if err != nil {
+		log.WithFields(log.Fields{"secpath": secpath, "error": err}).Error("got error while rendering credentials")
+		return nil, syscall.EIO
+	}
+	return content, fs.OK
+}
+
+// getCredentials returns the credentials of all hosts configured in
+// fio.credentials.hosts, which the calling user may read
+func getCredentials(ctx context.Context) ([]credential, error) {
+	var hosts []credentialsHost
+	if err := viper.UnmarshalKey("fio.credentials.hosts", &hosts); err != nil {
+		return nil, err
+	}
+	pu, err := newPathUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sto := *store.GetStore()
+	var creds []credential
+	for _, h := range hosts {
+		spath, err := renderPathTemplate(h.Path, pu)
+		if err != nil {
+			log.WithFields(log.Fields{"host": h.Host, "path": h.Path, "error": err}).Warn("got error while rendering path of host")
+			continue
+		}
+		data, err := store.GetSecretData(sto, spath, ctx)
+		if err != nil {
+			log.WithFields(log.Fields{"host": h.Host, "spath": spath, "username": pu.Username, "error": err}).Debug("leaving out host, secret can't be read")
+			continue
+		}
+		c := credential{
+			host:     h.Host,
+			username: store.ValueToString(data[viper.GetString("fio.credentials.usernamekey")]),
+			password: store.ValueToString(data[viper.GetString("fio.credentials.passwordkey")]),
+		}
+		if c.username == "" || c.password == "" {
+			log.WithFields(log.Fields{"host": h.Host, "spath": spath}).Warn("leaving out host, secret misses username or password")
+			continue
+		}
+		creds = append(creds, c)
+	}
+	return creds, nil
+}
+
+// renderNetrc renders creds as .netrc file. As netrc doesn't support any
+// quoting, credentials containing whitespace are left out.
+func renderNetrc(creds []credential) ([]byte, error) {
+	var buf bytes.Buffer
+	for _, c := range creds {
+		if strings.ContainsAny(c.host+c.username+c.password, " \t\r\n") {
+			log.WithFields(log.Fields{"host": c.host}).Warn("leaving out host in netrc, credentials contain whitespace")
+			continue
+		}
+		fmt.Fprintf(&buf, "machine %s login %s password %s\n", c.host, c.username, c.password)
+	}
+	return buf.Bytes(), nil
+}
+
+// renderGitCredentials renders creds as file for git's credential-store
+// helper
+func renderGitCredentials(creds []credential) ([]byte, error) {
+	var buf bytes.Buffer
+	for _, c := range creds {
+		u := url.URL{
+			Scheme: "https",
+			User:   url.UserPassword(c.username, c.password),
+			Host:   c.host,
+		}
+		fmt.Fprintln(&buf, u.String())
+	}
+	return buf.Bytes(), nil
+}
+
+// renderDockerConfig renders creds as docker config.json
+func renderDockerConfig(creds []credential) ([]byte, error) {
+	type auth struct {
+		Auth string `json:"auth"`
+	}
+	auths := make(map[string]auth)
+	for _, c := range creds {
+		auths[c.host] = auth{Auth: base64.StdEncoding.EncodeToString([]byte(c.username + ":" + c.password))}
+	}
+	content, err := json.MarshalIndent(map[string]interface{}{"auths": auths}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(content, '\n'), nil
+}
+
+func init() {
+	fioroot := FIOCredentials{}
+	fm := FIOMap{
+		Root: &fioroot,
+	}
+	RegisterRoot(&fm)
+}
@@ -0,0 +1,407 @@
+package secretsfs
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+
+	sfsfh "github.com/muryoutaisuu/secretsfs/pkg/fusehelpers"
+	"github.com/muryoutaisuu/secretsfs/pkg/store"
+)
+
+// FIODynamicSecrets issues credentials of vault secrets engines like database,
+// aws or rabbitmq on access. Following directory structure is implemented:
+//   	dynamic/
+//   	└── <mount>
+//   	  	└── creds
+//   	  	  	└── <role>
+//   	  	  	  	├── <key1>
+//   	  	  	  	└── <key2>
+// Credentials are only issued when a file is opened. Issued leases are cached
+// per user for their lease duration, so that all keys of a role belong to the
+// same credentials. While a file is held open its lease gets renewed. After
+// the last file of a lease got released, the lease gets revoked after
+// fio.dynamic.releasedelay.
+type FIODynamicSecrets struct {
+	mu     sync.Mutex
+	leases map[string]*dynamicLease
+}
+
+// dynamicLease is a cached lease together with its open file handles
+type dynamicLease struct {
+	*store.Lease
+	key     string
+	handles int
+	// stop ends the renewal of the lease, nil if not renewing
+	stop chan struct{}
+	// revoke is the pending revocation after the last release
+	revoke *time.Timer
+}
+
+// dynamicHandle is returned by Open, so that all reads of an opened file are
+// served from the same lease
+type dynamicHandle struct {
+	lease *dynamicLease
+	name  string
+}
+
+var _ = (FIORoot)((*FIODynamicSecrets)(nil))
+var _ = (FIOReleaser)((*FIODynamicSecrets)(nil))
+var _ = (FIOShutdowner)((*FIODynamicSecrets)(nil))
+
+func (sf *FIODynamicSecrets) Readdir(n *SfsNode, ctx context.Context) (out fs.DirStream, errno syscall.Errno) {
+	log.WithFields(log.Fields{"n": n, "n.npath": n.npath}).Debug("log values")
+
+	var direntries []fuse.DirEntry
+	parts := subPathParts(n.npath)
+	switch len(parts) {
+	case 0:
+		for _, m := range viper.GetStringSlice("fio.dynamic.mounts") {
+			direntries = append(direntries, getDirEntry(n.npath, m, fuse.S_IFDIR))
+		}
+	case 1:
+		if !isDynamicMount(parts[0]) {
+			return nil, syscall.ENOENT
+		}
+		direntries = append(direntries, getDirEntry(n.npath, "creds", fuse.S_IFDIR))
+	case 2:
+		roles, err := store.ListVault(parts[0]+"/roles", ctx)
+		if err != nil {
+			// roles may still be accessed directly
+			log.WithFields(log.Fields{"n.npath": n.npath, "error": err}).Warn("got error while listing roles, probably not enough permissions")
+		}
+		for _, r := range roles {
+			direntries = append(direntries, getDirEntry(n.npath, r, fuse.S_IFDIR))
+		}
+	case 3:
+		// listing must not issue credentials, so only keys of already issued
+		// credentials are shown
+		l, errno := sf.cachedLease(ctx, parts)
+		if errno != fs.OK {
+			return nil, errno
+		}
+		if l != nil {
+			for k := range l.Data {
+				direntries = append(direntries, getDirEntry(n.npath, k, fuse.S_IFREG))
+			}
+		}
+	default:
+		return nil, syscall.ENOTDIR
+	}
+	log.WithFields(log.Fields{"direntries": direntries}).Debug("log values")
+	return fs.NewListDirStream(direntries), fs.OK
+}
+
+func (sf *FIODynamicSecrets) Lookup(n *SfsNode, ctx context.Context, name string, out *fuse.EntryOut) (node *fs.Inode, errno syscall.Errno) {
+	log.WithFields(log.Fields{
+		"n":          n,
+		"n.npath":    n.npath,
+		"name":       name,
+		"out.NodeId": out.NodeId}).Debug("log values")
+
+	prefixedfullname := filepath.Join(n.npath, name)
+	parts := subPathParts(prefixedfullname)
+	switch len(parts) {
+	case 1:
+		if !isDynamicMount(parts[0]) {
+			return nil, syscall.ENOENT
+		}
+	case 2:
+		if parts[1] != "creds" {
+			return nil, syscall.ENOENT
+		}
+	case 3:
+		// roles are only checked on access, as they might not be listable
+	case 4:
+		// credentials are only issued on Open, so keys can only be checked
+		// against already issued credentials
+		l, errno := sf.cachedLease(ctx, parts[:3])
+		if errno != fs.OK {
+			return nil, errno
+		}
+		if l != nil {
+			if _, ok := l.Data[parts[3]]; !ok {
+				return nil, syscall.ENOENT
+			}
+		}
+		return getLookupChild(n, prefixedfullname, fuse.S_IFREG, ctx, out)
+	default:
+		return nil, syscall.ENOENT
+	}
+	return getLookupChild(n, prefixedfullname, fuse.S_IFDIR, ctx, out)
+}
+
+func (sf *FIODynamicSecrets) Open(n *SfsNode, ctx context.Context, flags uint32) (fh fs.FileHandle, fuseFlags uint32, errno syscall.Errno) {
+	log.WithFields(log.Fields{"n": n, "n.npath": n.npath}).Debug("log values")
+	parts := subPathParts(n.npath)
+	if len(parts) != 4 {
+		return nil, 0, syscall.EISDIR
+	}
+	l, errno := sf.getLease(ctx, parts[:3])
+	if errno != fs.OK {
+		return nil, 0, errno
+	}
+	if _, ok := l.Data[parts[3]]; !ok {
+		sf.releaseLease(l)
+		return nil, 0, syscall.ENOENT
+	}
+	// content may change on every issuance, so don't let the kernel cache it
+	return &dynamicHandle{lease: l, name: parts[3]}, fuse.FOPEN_DIRECT_IO, fs.OK
+}
+
+func (sf *FIODynamicSecrets) Read(n *SfsNode, ctx context.Context, f fs.FileHandle, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
+	log.WithFields(log.Fields{"n": n, "n.npath": n.npath}).Debug("log values")
+	parts := subPathParts(n.npath)
+	if len(parts) != 4 {
+		return nil, syscall.EISDIR
+	}
+	var l *dynamicLease
+	if h, ok := f.(*dynamicHandle); ok {
+		l = h.lease
+	} else {
+		var errno syscall.Errno
+		if l, errno = sf.cachedLease(ctx, parts[:3]); errno != fs.OK {
+			return nil, errno
+		}
+		if l == nil {
+			return nil, syscall.EBADF
+		}
+	}
+	v, ok := l.Data[parts[3]]
+	if !ok {
+		return nil, syscall.ENOENT
+	}
+	return readResultAt([]byte(store.ValueToString(v)), dest, off), fs.OK
+}
+
+func (sf *FIODynamicSecrets) Getattr(n *SfsNode, ctx context.Context, fh fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	log.WithFields(log.Fields{"n": n, "n.npath": n.npath}).Debug("log values")
+	parts := subPathParts(n.npath)
+	if len(parts) == 4 {
+		// the size is only known for already issued credentials, reads don't
+		// depend on it as files are opened with FOPEN_DIRECT_IO
+		l, errno := sf.cachedLease(ctx, parts[:3])
+		if errno != fs.OK {
+			return errno
+		}
+		if l != nil {
+			out.Size = uint64(len(store.ValueToString(l.Data[parts[3]])))
+		}
+	}
+	out.Ino = GetInode(n.npath)
+	return fs.OK
+}
+
+func (sf *FIODynamicSecrets) Release(n *SfsNode, ctx context.Context, f fs.FileHandle) syscall.Errno {
+	log.WithFields(log.Fields{"n": n, "n.npath": n.npath}).Debug("log values")
+	if h, ok := f.(*dynamicHandle); ok {
+		sf.releaseLease(h.lease)
+	}
+	return fs.OK
+}
+
+// Shutdown revokes all cached leases
+func (sf *FIODynamicSecrets) Shutdown() {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	for k, l := range sf.leases {
+		if l.stop != nil {
+			close(l.stop)
+			l.stop = nil
+		}
+		if l.revoke != nil {
+			l.revoke.Stop()
+		}
+		revokeLease(l.Lease)
+		delete(sf.leases, k)
+	}
+}
+
+func (sf *FIODynamicSecrets) FIOPath() string {
+	return "dynamic"
+}
+
+// leaseKey returns the cache key of the calling user and the vault path of
+// the credentials described by parts (mount, "creds", role)
+func leaseKey(ctx context.Context, parts []string) (string, string, syscall.Errno) {
+	if !isDynamicMount(parts[0]) || parts[1] != "creds" {
+		return "", "", syscall.ENOENT
+	}
+	u, err := sfsfh.GetUserFromContext(ctx)
+	if err != nil {
+		log.WithFields(log.Fields{"parts": parts, "error": err}).Error("got error while getting user from context")
+		return "", "", syscall.EACCES
+	}
+	credspath := strings.Join(parts, "/")
+	return u.Uid + ":" + credspath, credspath, fs.OK
+}
+
+// cachedLease returns the cached lease of the calling user for the
+// credentials described by parts, or nil if none was issued yet. It never
+// issues credentials.
+func (sf *FIODynamicSecrets) cachedLease(ctx context.Context, parts []string) (*dynamicLease, syscall.Errno) {
+	key, _, errno := leaseKey(ctx, parts)
+	if errno != fs.OK {
+		return nil, errno
+	}
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	return sf.lookupLease(key), fs.OK
+}
+
+// getLease returns the cached lease of the calling user for the credentials
+// described by parts and counts an open handle on it, which must be released
+// with releaseLease. If there is no valid lease, a new one will be issued.
+func (sf *FIODynamicSecrets) getLease(ctx context.Context, parts []string) (*dynamicLease, syscall.Errno) {
+	key, credspath, errno := leaseKey(ctx, parts)
+	if errno != fs.OK {
+		return nil, errno
+	}
+	l, err := sf.acquireLease(key, func() (*store.Lease, error) {
+		return store.ReadLease(credspath, ctx)
+	})
+	if err != nil {
+		log.WithFields(log.Fields{
+			"credspath": credspath,
+			"key":       key,
+			"error":     err}).Error("got error while issuing credentials")
+		return nil, syscall.EACCES
+	}
+	return l, fs.OK
+}
+
+// acquireLease returns the cached lease of key and counts an open handle on
+// it. If there is no valid lease, a new one is issued by calling issue. issue
+// is called without holding sf.mu, so that users don't wait on each other's
+// requests to vault.
+func (sf *FIODynamicSecrets) acquireLease(key string, issue func() (*store.Lease, error)) (*dynamicLease, error) {
+	sf.mu.Lock()
+	l := sf.lookupLease(key)
+	sf.mu.Unlock()
+
+	if l == nil {
+		lease, err := issue()
+		if err != nil {
+			return nil, err
+		}
+		sf.mu.Lock()
+		// another open may have issued credentials in the meantime
+		if l = sf.lookupLease(key); l == nil {
+			l = &dynamicLease{Lease: lease, key: key}
+			sf.leases[key] = l
+		} else {
+			// deferred before the unlock, so it runs without holding sf.mu
+			defer revokeLease(lease)
+		}
+	} else {
+		sf.mu.Lock()
+	}
+	defer sf.mu.Unlock()
+
+	l.handles++
+	if l.revoke != nil {
+		l.revoke.Stop()
+		l.revoke = nil
+	}
+	if l.Renewable && l.stop == nil {
+		l.stop = make(chan struct{})
+		go sf.renew(l, l.stop)
+	}
+	return l, nil
+}
+
+// releaseLease releases an open handle of l. After the last handle was
+// released, l gets revoked after fio.dynamic.releasedelay.
+func (sf *FIODynamicSecrets) releaseLease(l *dynamicLease) {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	l.handles--
+	if l.handles > 0 {
+		return
+	}
+	if l.stop != nil {
+		close(l.stop)
+		l.stop = nil
+	}
+	l.revoke = time.AfterFunc(viper.GetDuration("fio.dynamic.releasedelay"), func() {
+		sf.mu.Lock()
+		if l.handles > 0 {
+			sf.mu.Unlock()
+			return
+		}
+		if sf.leases[l.key] == l {
+			delete(sf.leases, l.key)
+		}
+		sf.mu.Unlock()
+		revokeLease(l.Lease)
+	})
+}
+
+// lookupLease returns the usable lease cached for key, or nil. sf.mu must be
+// held.
+func (sf *FIODynamicSecrets) lookupLease(key string) *dynamicLease {
+	l, ok := sf.leases[key]
+	if !ok {
+		return nil
+	}
+	if l.handles > 0 || l.Valid() {
+		return l
+	}
+	delete(sf.leases, key)
+	return nil
+}
+
+// revokeLease revokes l and logs errors
+func revokeLease(l *store.Lease) {
+	if err := l.Revoke(); err != nil {
+		log.WithFields(log.Fields{"leaseid": l.ID, "error": err}).Error("got error while revoking lease")
+	}
+}
+
+// renew renews l at half of its lease duration, until stop is closed or the
+// renewal fails
+func (sf *FIODynamicSecrets) renew(l *dynamicLease, stop chan struct{}) {
+	for {
+		d := l.Duration() / 2
+		if d < time.Second {
+			d = time.Second
+		}
+		select {
+		case <-stop:
+			return
+		case <-time.After(d):
+		}
+		if err := l.Renew(); err != nil {
+			log.WithFields(log.Fields{"leaseid": l.ID, "error": err}).Error("got error while renewing lease, lease will expire")
+			return
+		}
+	}
+}
+
+// isDynamicMount checks whether mount is configured in fio.dynamic.mounts
+func isDynamicMount(mount string) bool {
+	for _, m := range viper.GetStringSlice("fio.dynamic.mounts") {
+		if m == mount {
+			return true
+		}
+	}
+	return false
+}
+
+func init() {
+	fioroot := FIODynamicSecrets{
+		leases: make(map[string]*dynamicLease),
+	}
+	fm := FIOMap{
+		Root: &fioroot,
+	}
+	RegisterRoot(&fm)
+}
@@ -0,0 +1,76 @@
+package secretsfs
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"github.com/muryoutaisuu/secretsfs/pkg/store"
+)
+
+func TestAcquireLease(t *testing.T) {
+	viper.Set("fio.dynamic.releasedelay", "10ms")
+	sf := &FIODynamicSecrets{leases: make(map[string]*dynamicLease)}
+
+	// both opens issue concurrently, as issue is called without holding sf.mu
+	var entered sync.WaitGroup
+	entered.Add(2)
+	proceed := make(chan struct{})
+	issue := func() (*store.Lease, error) {
+		entered.Done()
+		<-proceed
+		return &store.Lease{Data: map[string]interface{}{"username": "v-alice"}}, nil
+	}
+	leases := make(chan *dynamicLease, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			l, err := sf.acquireLease("1000:database/creds/readonly", issue)
+			if err != nil {
+				t.Error(err)
+			}
+			leases <- l
+		}()
+	}
+	entered.Wait()
+	close(proceed)
+	l1, l2 := <-leases, <-leases
+	if l1 != l2 {
+		t.Fatalf("concurrent opens got different leases\n")
+	}
+	if l1.handles != 2 {
+		t.Errorf("handles was incorrect, got: '%v', want: '%v'\n", l1.handles, 2)
+	}
+
+	// a lease with open handles is reused without issuing
+	if _, err := sf.acquireLease("1000:database/creds/readonly", func() (*store.Lease, error) {
+		t.Errorf("issued credentials although a lease is open\n")
+		return nil, errors.New("unexpected")
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		sf.releaseLease(l1)
+	}
+	time.Sleep(100 * time.Millisecond)
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	if len(sf.leases) != 0 {
+		t.Errorf("released lease was not revoked\n")
+	}
+}
+
+func TestAcquireLeaseError(t *testing.T) {
+	sf := &FIODynamicSecrets{leases: make(map[string]*dynamicLease)}
+	if _, err := sf.acquireLease("1000:database/creds/readonly", func() (*store.Lease, error) {
+		return nil, errors.New("permission denied")
+	}); err == nil {
+		t.Errorf("expected error\n")
+	}
+	if len(sf.leases) != 0 {
+		t.Errorf("failed issuance was cached\n")
+	}
+}
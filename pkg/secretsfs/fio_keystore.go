@@ -0,0 +1,265 @@
+package secretsfs
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"fmt"
+	"path/filepath"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+
+	sfsfh "github.com/muryoutaisuu/secretsfs/pkg/fusehelpers"
+	"github.com/muryoutaisuu/secretsfs/pkg/keystore"
+	"github.com/muryoutaisuu/secretsfs/pkg/store"
+)
+
+// FIOKeystore assembles java keystores from PEM encoded keys and certificates
+// stored in a secret. Following directory structure is implemented:
+//   	keystore/
+//   	└── <path in store>
+//   	  	├── keystore.jks
+//   	  	├── keystore.p12
+//   	  	├── truststore.jks
+//   	  	└── <subdirectory>
+// The keys of the secret containing private key, certificate chain, CA
+// certificates and keystore password are configured in fio.keystore.keys.
+// Keystores are only shown, if the secret contains the keys they need.
+type FIOKeystore struct{}
+
+// keystoreMaterial contains the configured keys of a secret
+type keystoreMaterial struct {
+	alias    string
+	password string
+	data     map[string]string
+}
+
+// keystoreFile describes how a file of FIOKeystore is assembled
+type keystoreFile struct {
+	// keys contains the configuration names of the keys needed in the secret
+	keys   []string
+	encode func(m *keystoreMaterial) ([]byte, error)
+}
+
+// keystoreFiles maps the filenames of FIOKeystore to their description
+var keystoreFiles = map[string]keystoreFile{
+	"keystore.p12": {
+		keys: []string{"key", "cert", "password"},
+		encode: func(m *keystoreMaterial) ([]byte, error) {
+			key, certs, err := m.keyAndCerts()
+			if err != nil {
+				return nil, err
+			}
+			return keystore.EncodePKCS12(m.alias, key, certs, m.password)
+		},
+	},
+	"keystore.jks": {
+		keys: []string{"key", "cert", "password"},
+		encode: func(m *keystoreMaterial) ([]byte, error) {
+			key, certs, err := m.keyAndCerts()
+			if err != nil {
+				return nil, err
+			}
+			return keystore.EncodeJKS(m.alias, key, certs, m.password)
+		},
+	},
+	"truststore.jks": {
+		keys: []string{"ca", "password"},
+		encode: func(m *keystoreMaterial) ([]byte, error) {
+			cas, err := keystore.ParseCertificates([]byte(m.data["ca"]))
+			if err != nil {
+				return nil, err
+			}
+			return keystore.EncodeJKSTrustStore(m.alias, cas, m.password)
+		},
+	},
+}
+
+var _ = (FIORoot)((*FIOKeystore)(nil))
+
+func (sf *FIOKeystore) Readdir(n *SfsNode, ctx context.Context) (out fs.DirStream, errno syscall.Errno) {
+	log.WithFields(log.Fields{"n": n, "n.npath": n.npath}).Debug("log values")
+
+	_, secpath := rootName(n.npath)
+	sec, err := (*store.GetStore()).GetSecret(secpath, ctx)
+	if err != nil {
+		log.WithFields(log.Fields{"secpath": secpath, "error": err, "calling": "GetSecret(secpath, ctx)"}).Error("Got error while getting secret")
+		return nil, syscall.ENOENT
+	}
+	if !sfsfh.IsDir(sec.Mode) {
+		return nil, syscall.ENOTDIR
+	}
+
+	var direntries []fuse.DirEntry
+	for _, v := range sec.Subs {
+		if sfsfh.IsDir(v.Mode) {
+			direntries = append(direntries, getDirEntry(n.npath, filepath.Base(v.Path), fuse.S_IFDIR))
+		}
+	}
+	if m, err := getKeystoreMaterial(secpath, ctx); err == nil {
+		for name, f := range keystoreFiles {
+			if m.has(f.keys) {
+				direntries = append(direntries, getDirEntry(n.npath, name, fuse.S_IFREG))
+			}
+		}
+	}
+	log.WithFields(log.Fields{"direntries": direntries}).Debug("log values")
+	return fs.NewListDirStream(direntries), fs.OK
+}
+
+func (sf *FIOKeystore) Lookup(n *SfsNode, ctx context.Context, name string, out *fuse.EntryOut) (node *fs.Inode, errno syscall.Errno) {
+	log.WithFields(log.Fields{
+		"n":          n,
+		"n.npath":    n.npath,
+		"name":       name,
+		"out.NodeId": out.NodeId}).Debug("log values")
+
+	prefixedfullname := filepath.Join(n.npath, name)
+	_, secpath := rootName(n.npath)
+	if f, ok := keystoreFiles[name]; ok {
+		m, err := getKeystoreMaterial(secpath, ctx)
+		if err != nil || !m.has(f.keys) {
+			return nil, syscall.ENOENT
+		}
+		return getLookupChild(n, prefixedfullname, fuse.S_IFREG, ctx, out)
+	}
+	sec, err := (*store.GetStore()).GetSecret(filepath.Join(secpath, name), ctx)
+	if err != nil || !sfsfh.IsDir(sec.Mode) {
+		return nil, syscall.ENOENT
+	}
+	return getLookupChild(n, prefixedfullname, fuse.S_IFDIR, ctx, out)
+}
+
+func (sf *FIOKeystore) Open(n *SfsNode, ctx context.Context, flags uint32) (fh fs.FileHandle, fuseFlags uint32, errno syscall.Errno) {
+	log.WithFields(log.Fields{"n": n, "n.npath": n.npath}).Debug("log values")
+	content, errno := assembleKeystore(n.npath, ctx)
+	if errno != fs.OK {
+		return nil, 0, errno
+	}
+	// every assembly uses new random salts, so keep the content for all reads
+	return &contentHandle{content: content}, fuse.FOPEN_DIRECT_IO, fs.OK
+}
+
+func (sf *FIOKeystore) Read(n *SfsNode, ctx context.Context, f fs.FileHandle, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
+	log.WithFields(log.Fields{"n": n, "n.npath": n.npath}).Debug("log values")
+	// serve from the handle, so that reads at different offsets belong to the
+	// same keystore
+	if h, ok := f.(*contentHandle); ok {
+		return readResultAt(h.content, dest, off), fs.OK
+	}
+	content, errno := assembleKeystore(n.npath, ctx)
+	if errno != fs.OK {
+		return nil, errno
+	}
+	return readResultAt(content, dest, off), fs.OK
+}
+
+func (sf *FIOKeystore) Getattr(n *SfsNode, ctx context.Context, fh fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	log.WithFields(log.Fields{"n": n, "n.npath": n.npath}).Debug("log values")
+	// stat must not assemble keystores, as that reads every referenced
+	// secret, so the size is only known for opened files. Reads don't depend
+	// on it as files are opened with FOPEN_DIRECT_IO.
+	if h, ok := fh.(*contentHandle); ok {
+		out.Size = uint64(len(h.content))
+	}
+	out.Ino = GetInode(n.npath)
+	return fs.OK
+}
+
+func (sf *FIOKeystore) FIOPath() string {
+	return "keystore"
+}
+
+// assembleKeystore returns the keystore file at npath for the calling user
+func assembleKeystore(npath string, ctx context.Context) ([]byte, syscall.Errno) {
+	_, secpath := rootName(npath)
+	f, ok := keystoreFiles[filepath.Base(secpath)]
+	if !ok {
+		return nil, syscall.EISDIR
+	}
+	m, err := getKeystoreMaterial(parentPath(secpath), ctx)
+	if err != nil {
+		log.WithFields(log.Fields{"secpath": secpath, "error": err}).Error("got error while getting secret")
+		return nil, syscall.ENOENT
+	}
+	if !m.has(f.keys) {
+		return nil, syscall.ENOENT
+	}
+	content, err := f.encode(m)
+	if err != nil {
+		log.WithFields(log.Fields{"secpath": secpath, "error": err}).Error("got error while assembling keystore")
+		return nil, syscall.EIO
+	}
+	return content, fs.OK
+}
+
+// getKeystoreMaterial reads all keys configured in fio.keystore.keys from the
+// secret at spath
+func getKeystoreMaterial(spath string, ctx context.Context) (*keystoreMaterial, error) {
+	data, err := store.GetSecretData(*store.GetStore(), spath, ctx)
+	if err != nil {
+		return nil, err
+	}
+	m := &keystoreMaterial{
+		alias: viper.GetString("fio.keystore.alias"),
+		data:  make(map[string]string),
+	}
+	if m.alias == "" && spath != "" {
+		m.alias = filepath.Base(spath)
+	}
+	for name, key := range viper.GetStringMapString("fio.keystore.keys") {
+		if v, ok := data[key]; ok {
+			m.data[name] = store.ValueToString(v)
+		}
+	}
+	m.password = m.data["password"]
+	return m, nil
+}
+
+// has checks whether all keys configured for names are contained
+func (m *keystoreMaterial) has(names []string) bool {
+	for _, name := range names {
+		if _, ok := m.data[name]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// keyAndCerts returns the private key together with its certificate chain
+func (m *keystoreMaterial) keyAndCerts() (interface{}, []*x509.Certificate, error) {
+	key, err := keystore.ParsePrivateKey([]byte(m.data["key"]))
+	if err != nil {
+		return nil, nil, err
+	}
+	certs, err := keystore.ParseCertificates([]byte(m.data["cert"]))
+	if err != nil {
+		return nil, nil, err
+	}
+	if !publicKeyMatches(certs[0], key) {
+		return nil, nil, fmt.Errorf("msg=\"certificate does not belong to private key\" subject=\"%v\"\n", certs[0].Subject)
+	}
+	return key, certs, nil
+}
+
+// publicKeyMatches checks whether cert contains the public key of key
+func publicKeyMatches(cert *x509.Certificate, key interface{}) bool {
+	pk, ok := key.(interface{ Public() crypto.PublicKey })
+	if !ok {
+		return false
+	}
+	pub, ok := pk.Public().(interface{ Equal(crypto.PublicKey) bool })
+	return ok && pub.Equal(cert.PublicKey)
+}
+
+func init() {
+	fioroot := FIOKeystore{}
+	fm := FIOMap{
+		Root: &fioroot,
+	}
+	RegisterRoot(&fm)
+}
@@ -0,0 +1,156 @@
+package secretsfs
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+
+	sfsfh "github.com/muryoutaisuu/secretsfs/pkg/fusehelpers"
+	"github.com/muryoutaisuu/secretsfs/pkg/store"
+)
+
+// FIOOverlay merges ordered lists of store paths, configured as views in
+// fio.overlay.views. Following directory structure is implemented:
+//   	overlay/
+//   	└── <view>
+//   	  	└── <path below the layers of the view>
+// Keys of later layers override keys of earlier layers, listings show the
+// union of all layers.
+type FIOOverlay struct{}
+
+var _ = (FIORoot)((*FIOOverlay)(nil))
+
+func (sf *FIOOverlay) Readdir(n *SfsNode, ctx context.Context) (out fs.DirStream, errno syscall.Errno) {
+	log.WithFields(log.Fields{"n": n, "n.npath": n.npath}).Debug("log values")
+
+	_, secpath := rootName(n.npath)
+	var direntries []fuse.DirEntry
+	if secpath == "" {
+		for view := range viper.GetStringMapStringSlice("fio.overlay.views") {
+			direntries = append(direntries, getDirEntry(n.npath, view, fuse.S_IFDIR))
+		}
+		return fs.NewListDirStream(direntries), fs.OK
+	}
+	sec, errno := getOverlaySecret(secpath, ctx)
+	if errno != fs.OK {
+		return nil, errno
+	}
+	if !sfsfh.IsDir(sec.Mode) {
+		return nil, syscall.ENOTDIR
+	}
+	for _, v := range sec.Subs {
+		var mode uint32 = fuse.S_IFREG
+		if sfsfh.IsDir(v.Mode) {
+			mode = fuse.S_IFDIR
+		}
+		direntries = append(direntries, getDirEntry(n.npath, filepath.Base(v.Path), mode))
+	}
+	log.WithFields(log.Fields{"direntries": direntries}).Debug("log values")
+	return fs.NewListDirStream(direntries), fs.OK
+}
+
+func (sf *FIOOverlay) Lookup(n *SfsNode, ctx context.Context, name string, out *fuse.EntryOut) (node *fs.Inode, errno syscall.Errno) {
+	log.WithFields(log.Fields{
+		"n":          n,
+		"n.npath":    n.npath,
+		"name":       name,
+		"out.NodeId": out.NodeId}).Debug("log values")
+
+	prefixedfullname := filepath.Join(n.npath, name)
+	_, secpath := rootName(prefixedfullname)
+	sec, errno := getOverlaySecret(secpath, ctx)
+	if errno != fs.OK {
+		return nil, errno
+	}
+	var mode uint32 = fuse.S_IFREG
+	if sfsfh.IsDir(sec.Mode) {
+		mode = fuse.S_IFDIR
+	}
+	return getLookupChild(n, prefixedfullname, mode, ctx, out)
+}
+
+func (sf *FIOOverlay) Open(n *SfsNode, ctx context.Context, flags uint32) (fh fs.FileHandle, fuseFlags uint32, errno syscall.Errno) {
+	log.WithFields(log.Fields{"n": n, "n.npath": n.npath}).Debug("log values")
+	return nil, 0, fs.OK
+}
+
+func (sf *FIOOverlay) Read(n *SfsNode, ctx context.Context, f fs.FileHandle, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
+	log.WithFields(log.Fields{"n": n, "n.npath": n.npath}).Debug("log values")
+	_, secpath := rootName(n.npath)
+	sec, errno := getOverlaySecret(secpath, ctx)
+	if errno != fs.OK {
+		return nil, errno
+	}
+	if !sfsfh.IsFile(sec.Mode) {
+		return nil, syscall.EISDIR
+	}
+	return readResultAt(sec.Content, dest, off), fs.OK
+}
+
+func (sf *FIOOverlay) Getattr(n *SfsNode, ctx context.Context, fh fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	log.WithFields(log.Fields{"n": n, "n.npath": n.npath}).Debug("log values")
+	out.Ino = GetInode(n.npath)
+	if IsRootPath(n.npath) || n.IsDir() {
+		return fs.OK
+	}
+	_, secpath := rootName(n.npath)
+	sec, errno := getOverlaySecret(secpath, ctx)
+	if errno != fs.OK {
+		return errno
+	}
+	out.Size = uint64(len(sec.Content))
+	return fs.OK
+}
+
+func (sf *FIOOverlay) FIOPath() string {
+	return "overlay"
+}
+
+// getOverlaySecret returns the merged secret at secpath, which starts with
+// the name of the view
+func getOverlaySecret(secpath string, ctx context.Context) (*store.Secret, syscall.Errno) {
+	parts := strings.SplitN(secpath, "/", 2)
+	sto, ok := getOverlayStore(parts[0])
+	if !ok {
+		return nil, syscall.ENOENT
+	}
+	var spath string
+	if len(parts) == 2 {
+		spath = parts[1]
+	}
+	sec, err := sto.GetSecret(spath, ctx)
+	if err != nil {
+		log.WithFields(log.Fields{"secpath": secpath, "error": err}).Debug("got error while getting secret")
+		return nil, syscall.ENOENT
+	}
+	return sec, fs.OK
+}
+
+// getOverlayStore returns a store merging the layers of view
+func getOverlayStore(view string) (store.Store, bool) {
+	paths, ok := viper.GetStringMapStringSlice("fio.overlay.views")[view]
+	if !ok {
+		return nil, false
+	}
+	// the layered store lets the first layer win, views the last one
+	sto := *store.GetStore()
+	layers := make([]store.Store, 0, len(paths))
+	for i := len(paths) - 1; i >= 0; i-- {
+		layers = append(layers, &store.Prefixed{Store: sto, Prefix: paths[i]})
+	}
+	return store.NewLayered(layers...), true
+}
+
+func init() {
+	fioroot := FIOOverlay{}
+	fm := FIOMap{
+		Root: &fioroot,
+	}
+	RegisterRoot(&fm)
+}
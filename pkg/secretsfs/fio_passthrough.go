@@ -0,0 +1,356 @@
+package secretsfs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+
+	sfsfh "github.com/muryoutaisuu/secretsfs/pkg/fusehelpers"
+)
+
+// FIOPassthrough mirrors the directories configured in fio.passthrough.paths,
+// e.g. checked out configuration repositories. Following directory structure
+// is implemented:
+//   	passthrough/
+//   	└── <name of path>
+//   	  	├── <regular file>
+//   	  	├── <template without extension>
+//   	  	└── <subdirectory>
+// Regular files are shown unchanged, while files matching
+// fio.passthrough.pattern are rendered like templatefiles for the calling user
+// and shown without their extension. A rendered template hides a regular file
+// of the same name.
+// Files and directories are only served, if the calling user may read them
+// according to their unix permissions, as secretsfs itself generally runs as
+// root. Symlinks are only followed within the mirrored directory.
+type FIOPassthrough struct{}
+
+// passthroughEntry is a file or directory shown by FIOPassthrough
+type passthroughEntry struct {
+	// unixpath is the path on disk with all symlinks resolved
+	unixpath string
+	info     os.FileInfo
+	template bool
+	// name is the name of the mirrored directory in fio.passthrough.paths and
+	// subpath the path of the entry below it, as shown
+	name    string
+	subpath string
+}
+
+var _ = (FIORoot)((*FIOPassthrough)(nil))
+var _ = (FIOReleaser)((*FIOPassthrough)(nil))
+
+func (sf *FIOPassthrough) Readdir(n *SfsNode, ctx context.Context) (out fs.DirStream, errno syscall.Errno) {
+	log.WithFields(log.Fields{"n": n, "n.npath": n.npath}).Debug("log values")
+
+	var direntries []fuse.DirEntry
+	if IsRootPath(n.npath) {
+		for name := range viper.GetStringMapString("fio.passthrough.paths") {
+			direntries = append(direntries, getDirEntry(n.npath, name, fuse.S_IFDIR))
+		}
+		return fs.NewListDirStream(direntries), fs.OK
+	}
+	e, errno := getPassthroughEntry(n.npath)
+	if errno != fs.OK {
+		return nil, errno
+	}
+	if !e.info.IsDir() {
+		return nil, syscall.ENOTDIR
+	}
+	if errno := e.checkAccess(ctx); errno != fs.OK {
+		return nil, errno
+	}
+	root, err := passthroughRoot(e.name)
+	if err != nil {
+		return nil, syscall.ENOENT
+	}
+	entries, err := readPassthroughDir(root, e.unixpath, viper.GetString("fio.passthrough.pattern"))
+	if err != nil {
+		log.WithFields(log.Fields{"unixpath": e.unixpath, "error": err}).Error("got error while reading passthrough directory")
+		return nil, syscall.ENOENT
+	}
+	for name, entry := range entries {
+		direntries = append(direntries, getDirEntry(n.npath, name, getModeFromFileInfo(entry.info)))
+	}
+	log.WithFields(log.Fields{"direntries": direntries}).Debug("log values")
+	return fs.NewListDirStream(direntries), fs.OK
+}
+
+func (sf *FIOPassthrough) Lookup(n *SfsNode, ctx context.Context, name string, out *fuse.EntryOut) (node *fs.Inode, errno syscall.Errno) {
+	log.WithFields(log.Fields{
+		"n":          n,
+		"n.npath":    n.npath,
+		"name":       name,
+		"out.NodeId": out.NodeId}).Debug("log values")
+
+	prefixedfullname := filepath.Join(n.npath, name)
+	e, errno := getPassthroughEntry(prefixedfullname)
+	if errno != fs.OK {
+		return nil, errno
+	}
+	return getLookupChild(n, prefixedfullname, getModeFromFileInfo(e.info), ctx, out)
+}
+
+func (sf *FIOPassthrough) Open(n *SfsNode, ctx context.Context, flags uint32) (fh fs.FileHandle, fuseFlags uint32, errno syscall.Errno) {
+	log.WithFields(log.Fields{"n": n, "n.npath": n.npath}).Debug("log values")
+	e, errno := getPassthroughEntry(n.npath)
+	if errno != fs.OK {
+		return nil, 0, errno
+	}
+	if !e.template {
+		// the opened file is used as file handle, so that the permissions of
+		// the calling user are checked by open and reads don't open it again
+		file, errno := e.open(ctx)
+		if errno != fs.OK {
+			return nil, 0, errno
+		}
+		return file, 0, fs.OK
+	}
+	content, errno := renderPassthroughTemplate(e, ctx)
+	if errno != fs.OK {
+		return nil, 0, errno
+	}
+	// rendered content depends on the calling user, so don't let the kernel
+	// cache it
+	return &contentHandle{content: content}, fuse.FOPEN_DIRECT_IO, fs.OK
+}
+
+func (sf *FIOPassthrough) Read(n *SfsNode, ctx context.Context, f fs.FileHandle, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
+	log.WithFields(log.Fields{"n": n, "n.npath": n.npath}).Debug("log values")
+	switch h := f.(type) {
+	case *contentHandle:
+		return readResultAt(h.content, dest, off), fs.OK
+	case *os.File:
+		return readFileAt(h, dest, off)
+	}
+	e, errno := getPassthroughEntry(n.npath)
+	if errno != fs.OK {
+		return nil, errno
+	}
+	if e.template {
+		content, errno := renderPassthroughTemplate(e, ctx)
+		if errno != fs.OK {
+			return nil, errno
+		}
+		return readResultAt(content, dest, off), fs.OK
+	}
+	file, errno := e.open(ctx)
+	if errno != fs.OK {
+		return nil, errno
+	}
+	defer file.Close()
+	return readFileAt(file, dest, off)
+}
+
+func (sf *FIOPassthrough) Release(n *SfsNode, ctx context.Context, f fs.FileHandle) syscall.Errno {
+	log.WithFields(log.Fields{"n": n, "n.npath": n.npath}).Debug("log values")
+	if file, ok := f.(*os.File); ok {
+		file.Close()
+	}
+	return fs.OK
+}
+
+func (sf *FIOPassthrough) Getattr(n *SfsNode, ctx context.Context, fh fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	log.WithFields(log.Fields{"n": n, "n.npath": n.npath}).Debug("log values")
+	out.Ino = GetInode(n.npath)
+	if IsRootPath(n.npath) {
+		return fs.OK
+	}
+	e, errno := getPassthroughEntry(n.npath)
+	if errno != fs.OK {
+		return errno
+	}
+	out.Mtime = uint64(e.info.ModTime().Unix())
+	out.Ctime = out.Mtime
+	switch {
+	case e.info.IsDir():
+	case e.template:
+		content, errno := renderPassthroughTemplate(e, ctx)
+		if errno != fs.OK {
+			return errno
+		}
+		out.Size = uint64(len(content))
+	default:
+		out.Size = uint64(e.info.Size())
+	}
+	return fs.OK
+}
+
+func (sf *FIOPassthrough) FIOPath() string {
+	return "passthrough"
+}
+
+// getPassthroughEntry resolves npath to the file or directory it shows
+func getPassthroughEntry(npath string) (*passthroughEntry, syscall.Errno) {
+	name, subpath := getTemplateSubPaths(npath)
+	root, err := passthroughRoot(name)
+	if err != nil {
+		log.WithFields(log.Fields{"name": name, "error": err}).Debug("passthrough path is not available")
+		return nil, syscall.ENOENT
+	}
+	if subpath == "" {
+		info, err := os.Stat(root)
+		if err != nil || !info.IsDir() {
+			log.WithFields(log.Fields{"name": name, "root": root, "error": err}).Error("passthrough path is not a directory")
+			return nil, syscall.ENOENT
+		}
+		return &passthroughEntry{unixpath: root, info: info, name: name}, fs.OK
+	}
+	// only templates are renamed, so all parents exist on disk as they are
+	entries, err := readPassthroughDir(root, filepath.Join(root, parentPath(subpath)), viper.GetString("fio.passthrough.pattern"))
+	if err != nil {
+		return nil, syscall.ENOENT
+	}
+	e, ok := entries[filepath.Base(subpath)]
+	if !ok {
+		return nil, syscall.ENOENT
+	}
+	e.name, e.subpath = name, subpath
+	return e, fs.OK
+}
+
+// passthroughRoot returns the mirrored directory name in
+// fio.passthrough.paths with all symlinks resolved
+func passthroughRoot(name string) (string, error) {
+	root, ok := viper.GetStringMapString("fio.passthrough.paths")[name]
+	if !ok {
+		return "", fmt.Errorf("msg=\"no such passthrough path\" name=\"%v\"\n", name)
+	}
+	return filepath.EvalSymlinks(root)
+}
+
+// readPassthroughDir returns the entries of unixdir by their shown names,
+// regular files matching pattern are templates. Symlinks are followed, if
+// they point to a file or directory below root, which must not contain
+// symlinks itself. Other special files are left out.
+func readPassthroughDir(root, unixdir, pattern string) (map[string]*passthroughEntry, error) {
+	realdir, err := filepath.EvalSymlinks(unixdir)
+	if err != nil {
+		return nil, err
+	}
+	if !isBelow(root, realdir) {
+		return nil, fmt.Errorf("msg=\"directory is outside of the passthrough path\" unixdir=\"%v\" root=\"%v\"\n", unixdir, root)
+	}
+	files, err := ioutil.ReadDir(realdir)
+	if err != nil {
+		return nil, err
+	}
+	entries := make(map[string]*passthroughEntry)
+	for _, f := range files {
+		e := &passthroughEntry{unixpath: filepath.Join(realdir, f.Name()), info: f}
+		if f.Mode()&os.ModeSymlink != 0 {
+			target, err := filepath.EvalSymlinks(e.unixpath)
+			if err != nil || !isBelow(root, target) {
+				log.WithFields(log.Fields{"unixpath": e.unixpath, "target": target, "error": err}).Debug("leaving out symlink pointing outside of passthrough path")
+				continue
+			}
+			if e.info, err = os.Stat(target); err != nil {
+				continue
+			}
+			e.unixpath = target
+		}
+		if !e.info.IsDir() && !e.info.Mode().IsRegular() {
+			continue
+		}
+		name := f.Name()
+		if matched, _ := filepath.Match(pattern, name); matched && pattern != "" && e.info.Mode().IsRegular() {
+			e.template = true
+			name = strings.TrimSuffix(name, filepath.Ext(name))
+		} else if existing, ok := entries[name]; ok && existing.template {
+			continue
+		}
+		if name == "" {
+			continue
+		}
+		entries[name] = e
+	}
+	return entries, nil
+}
+
+// isBelow checks whether p is root or below it
+func isBelow(root, p string) bool {
+	rel, err := filepath.Rel(root, p)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// open opens e on behalf of the calling user, see sfsfh.OpenForUser
+func (e *passthroughEntry) open(ctx context.Context) (*os.File, syscall.Errno) {
+	u, err := sfsfh.GetUserFromContext(ctx)
+	if err != nil {
+		log.WithFields(log.Fields{"unixpath": e.unixpath, "error": err}).Error("got error while getting user from context")
+		return nil, syscall.EACCES
+	}
+	f, err := sfsfh.OpenForUser(e.unixpath, u)
+	if err != nil {
+		log.WithFields(log.Fields{"unixpath": e.unixpath, "username": u.Username, "error": err}).Warn("calling user may not read passthrough file")
+		return nil, syscall.EACCES
+	}
+	return f, fs.OK
+}
+
+// checkAccess checks whether the calling user may read e
+func (e *passthroughEntry) checkAccess(ctx context.Context) syscall.Errno {
+	f, errno := e.open(ctx)
+	if errno != fs.OK {
+		return errno
+	}
+	f.Close()
+	return fs.OK
+}
+
+// readFileAt reads up to len(dest) bytes of file at off
+func readFileAt(file *os.File, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
+	n, err := file.ReadAt(dest, off)
+	if err != nil && err != io.EOF {
+		log.WithFields(log.Fields{"file": file.Name(), "error": err}).Error("got error while reading passthrough file")
+		return nil, syscall.EIO
+	}
+	return fuse.ReadResultData(dest[:n]), fs.OK
+}
+
+// renderPassthroughTemplate renders the template e for the calling user. The
+// user must be allowed to read the template and the ACLs in
+// fio.passthrough.acls must permit the access, the template may only
+// reference the store paths in fio.passthrough.allowedpaths. The template is
+// rendered from the file opened on behalf of the user.
+func renderPassthroughTemplate(e *passthroughEntry, ctx context.Context) ([]byte, syscall.Errno) {
+	file, errno := e.open(ctx)
+	if errno != fs.OK {
+		return nil, errno
+	}
+	defer file.Close()
+	if errno := checkACLs("passthrough", filepath.Join(e.name, e.subpath), ctx); errno != fs.OK {
+		return nil, errno
+	}
+	content, err := renderOpenedTemplatefile("", e.unixpath, file, allowedPaths("passthrough", e.name), &ctx)
+	if err != nil {
+		log.WithFields(log.Fields{"unixpath": e.unixpath, "error": err}).Error("got error while rendering templatefile")
+		return nil, syscall.EIO
+	}
+	return content, fs.OK
+}
+
+func init() {
+	fioroot := FIOPassthrough{}
+	fm := FIOMap{
+		Root: &fioroot,
+	}
+	RegisterRoot(&fm)
+	if fm.Enabled {
+		var names []string
+		for name := range viper.GetStringMapString("fio.passthrough.paths") {
+			names = append(names, name)
+		}
+		warnUnrestricted("passthrough", names)
+	}
+}
@@ -0,0 +1,122 @@
+package secretsfs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadPassthroughDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "passthrough")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if dir, err = filepath.EvalSymlinks(dir); err != nil {
+		t.Fatal(err)
+	}
+	outside, err := ioutil.TempDir("", "outside")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(outside)
+	if err := ioutil.WriteFile(filepath.Join(outside, "shadow"), []byte("secret"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"app.conf", "db.conf", "db.conf.tmpl", "secret.yaml.tmpl", "README.md"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "conf.d"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	links := map[string]string{
+		"README":      "README.md",
+		"shadow":      filepath.Join(outside, "shadow"),
+		"outside":     outside,
+		"escape.tmpl": "../" + filepath.Base(outside) + "/shadow",
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(dir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := readPassthroughDir(dir, dir, "*.tmpl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tables := []struct {
+		name     string
+		file     string
+		template bool
+		dir      bool
+	}{
+		{"app.conf", "app.conf", false, false},
+		{"db.conf", "db.conf.tmpl", true, false},
+		{"secret.yaml", "secret.yaml.tmpl", true, false},
+		{"README.md", "README.md", false, false},
+		// symlinks are resolved, if they don't point outside of the root
+		{"README", "README.md", false, false},
+		{"conf.d", "conf.d", false, true},
+	}
+	if len(entries) != len(tables) {
+		t.Errorf("number of entries was incorrect, got: '%v', want: '%v'\n", len(entries), len(tables))
+	}
+	for _, table := range tables {
+		e, ok := entries[table.name]
+		if !ok {
+			t.Errorf("entry '%v' is missing\n", table.name)
+			continue
+		}
+		if e.unixpath != filepath.Join(dir, table.file) {
+			t.Errorf("unixpath of '%v' was incorrect, got: '%v', want: '%v'\n", table.name, e.unixpath, filepath.Join(dir, table.file))
+		}
+		if e.template != table.template {
+			t.Errorf("template of '%v' was incorrect, got: '%v', want: '%v'\n", table.name, e.template, table.template)
+		}
+		if e.info.IsDir() != table.dir {
+			t.Errorf("dir of '%v' was incorrect, got: '%v', want: '%v'\n", table.name, e.info.IsDir(), table.dir)
+		}
+	}
+
+	// directories outside of the root are not read at all
+	if _, err := readPassthroughDir(dir, outside, "*.tmpl"); err == nil {
+		t.Errorf("expected error for directory outside of the root\n")
+	}
+}
+
+func TestReadFileAt(t *testing.T) {
+	file, err := ioutil.TempFile("", "passthrough")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+	if _, err := file.WriteString("0123456789"); err != nil {
+		t.Fatal(err)
+	}
+	tables := []struct {
+		off  int64
+		size int
+		want string
+	}{
+		{0, 4, "0123"},
+		{6, 4, "6789"},
+		{8, 4, "89"},
+		{10, 4, ""},
+	}
+	for _, table := range tables {
+		res, errno := readFileAt(file, make([]byte, table.size), table.off)
+		if errno != 0 {
+			t.Errorf("got errno for off '%v': %v\n", table.off, errno)
+			continue
+		}
+		got, _ := res.Bytes(nil)
+		if string(got) != table.want {
+			t.Errorf("read at '%v' was incorrect, got: '%v', want: '%v'\n", table.off, string(got), table.want)
+		}
+	}
+}
@@ -0,0 +1,334 @@
+package secretsfs
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+
+	sfsfh "github.com/muryoutaisuu/secretsfs/pkg/fusehelpers"
+	"github.com/muryoutaisuu/secretsfs/pkg/store"
+)
+
+// FIOPKI issues certificates with vault's pki secrets engine for the calling
+// user. Following directory structure is implemented:
+//   	pki/
+//   	└── <mount>
+//   	  	└── <role>
+//   	  	  	└── <common-name>
+//   	  	  	  	├── ca.pem
+//   	  	  	  	├── cert.pem
+//   	  	  	  	└── key.pem
+// Issued certificates are cached per user, until fio.pki.renewfraction of
+// their lifetime has elapsed. Afterwards they are reissued on next opening.
+// Certificates are only issued when a file is opened, expired ones are
+// dropped from the cache.
+type FIOPKI struct {
+	mu      sync.Mutex
+	bundles map[string]*pkiBundle
+}
+
+// pkiBundle contains an issued certificate together with its key and ca
+type pkiBundle struct {
+	files    map[string][]byte
+	renewAt  time.Time
+	notAfter time.Time
+}
+
+// pkiFiles maps the filenames of a bundle to the keys returned by vault
+var pkiFiles = map[string]string{
+	"ca.pem":   "issuing_ca",
+	"cert.pem": "certificate",
+	"key.pem":  "private_key",
+}
+
+var _ = (FIORoot)((*FIOPKI)(nil))
+
+func (sf *FIOPKI) Readdir(n *SfsNode, ctx context.Context) (out fs.DirStream, errno syscall.Errno) {
+	log.WithFields(log.Fields{"n": n, "n.npath": n.npath}).Debug("log values")
+
+	var direntries []fuse.DirEntry
+	parts := subPathParts(n.npath)
+	switch len(parts) {
+	case 0:
+		for _, m := range viper.GetStringSlice("fio.pki.mounts") {
+			direntries = append(direntries, getDirEntry(n.npath, m, fuse.S_IFDIR))
+		}
+	case 1:
+		if !isPKIMount(parts[0]) {
+			return nil, syscall.ENOENT
+		}
+		roles, err := store.ListVault(parts[0]+"/roles", ctx)
+		if err != nil {
+			// roles may still be accessed directly
+			log.WithFields(log.Fields{"n.npath": n.npath, "error": err}).Warn("got error while listing roles, probably not enough permissions")
+		}
+		for _, r := range roles {
+			direntries = append(direntries, getDirEntry(n.npath, r, fuse.S_IFDIR))
+		}
+	case 2:
+		// common names can't be listed, so only show already issued ones
+		for _, cn := range sf.issuedCommonNames(ctx, parts) {
+			direntries = append(direntries, getDirEntry(n.npath, cn, fuse.S_IFDIR))
+		}
+	case 3:
+		for name := range pkiFiles {
+			direntries = append(direntries, getDirEntry(n.npath, name, fuse.S_IFREG))
+		}
+	default:
+		return nil, syscall.ENOTDIR
+	}
+	log.WithFields(log.Fields{"direntries": direntries}).Debug("log values")
+	return fs.NewListDirStream(direntries), fs.OK
+}
+
+func (sf *FIOPKI) Lookup(n *SfsNode, ctx context.Context, name string, out *fuse.EntryOut) (node *fs.Inode, errno syscall.Errno) {
+	log.WithFields(log.Fields{
+		"n":          n,
+		"n.npath":    n.npath,
+		"name":       name,
+		"out.NodeId": out.NodeId}).Debug("log values")
+
+	prefixedfullname := filepath.Join(n.npath, name)
+	parts := subPathParts(prefixedfullname)
+	switch len(parts) {
+	case 1:
+		if !isPKIMount(parts[0]) {
+			return nil, syscall.ENOENT
+		}
+	case 2, 3:
+		// roles and common names are only checked on issuance
+	case 4:
+		if _, ok := pkiFiles[parts[3]]; !ok {
+			return nil, syscall.ENOENT
+		}
+		return getLookupChild(n, prefixedfullname, fuse.S_IFREG, ctx, out)
+	default:
+		return nil, syscall.ENOENT
+	}
+	return getLookupChild(n, prefixedfullname, fuse.S_IFDIR, ctx, out)
+}
+
+func (sf *FIOPKI) Open(n *SfsNode, ctx context.Context, flags uint32) (fh fs.FileHandle, fuseFlags uint32, errno syscall.Errno) {
+	log.WithFields(log.Fields{"n": n, "n.npath": n.npath}).Debug("log values")
+	parts := subPathParts(n.npath)
+	if len(parts) != 4 {
+		return nil, 0, syscall.EISDIR
+	}
+	b, errno := sf.getBundle(ctx, parts[:3])
+	if errno != fs.OK {
+		return nil, 0, errno
+	}
+	// certificates are reissued transparently, so don't let the kernel cache them
+	return b, fuse.FOPEN_DIRECT_IO, fs.OK
+}
+
+func (sf *FIOPKI) Read(n *SfsNode, ctx context.Context, f fs.FileHandle, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
+	log.WithFields(log.Fields{"n": n, "n.npath": n.npath}).Debug("log values")
+	parts := subPathParts(n.npath)
+	if len(parts) != 4 {
+		return nil, syscall.EISDIR
+	}
+	// serve from the bundle of the opened file, so that reads stay consistent
+	b, ok := f.(*pkiBundle)
+	if !ok {
+		var errno syscall.Errno
+		if b, errno = sf.getBundle(ctx, parts[:3]); errno != fs.OK {
+			return nil, errno
+		}
+	}
+	content, ok := b.files[parts[3]]
+	if !ok {
+		return nil, syscall.ENOENT
+	}
+	return readResultAt(content, dest, off), fs.OK
+}
+
+func (sf *FIOPKI) Getattr(n *SfsNode, ctx context.Context, fh fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	log.WithFields(log.Fields{"n": n, "n.npath": n.npath}).Debug("log values")
+	parts := subPathParts(n.npath)
+	if len(parts) == 4 {
+		// stat must not issue certificates, so the size is only known for
+		// already issued ones. Reads don't depend on it as files are opened
+		// with FOPEN_DIRECT_IO.
+		if b := sf.cachedBundle(ctx, parts[:3]); b != nil {
+			out.Size = uint64(len(b.files[parts[3]]))
+		}
+	}
+	out.Ino = GetInode(n.npath)
+	return fs.OK
+}
+
+func (sf *FIOPKI) FIOPath() string {
+	return "pki"
+}
+
+// bundleKey returns the cache key of the calling user for the certificate
+// described by parts (mount, role, common name)
+func bundleKey(ctx context.Context, parts []string) (string, syscall.Errno) {
+	if !isPKIMount(parts[0]) {
+		return "", syscall.ENOENT
+	}
+	u, err := sfsfh.GetUserFromContext(ctx)
+	if err != nil {
+		log.WithFields(log.Fields{"parts": parts, "error": err}).Error("got error while getting user from context")
+		return "", syscall.EACCES
+	}
+	return u.Uid + ":" + strings.Join(parts, "/"), fs.OK
+}
+
+// cachedBundle returns the cached bundle of the calling user for the
+// certificate described by parts, or nil if none was issued yet. It never
+// issues certificates.
+func (sf *FIOPKI) cachedBundle(ctx context.Context, parts []string) *pkiBundle {
+	key, errno := bundleKey(ctx, parts)
+	if errno != fs.OK {
+		return nil
+	}
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	if b, ok := sf.bundles[key]; ok && time.Now().Before(b.notAfter) {
+		return b
+	}
+	return nil
+}
+
+// getBundle returns the cached bundle of the calling user for the certificate
+// described by parts (mount, role, common name). If there is no bundle or it
+// is due for renewal, a new certificate gets issued. Issuing happens without
+// holding sf.mu, so that users don't wait on each other's requests to vault.
+func (sf *FIOPKI) getBundle(ctx context.Context, parts []string) (*pkiBundle, syscall.Errno) {
+	key, errno := bundleKey(ctx, parts)
+	if errno != fs.OK {
+		return nil, errno
+	}
+	sf.mu.Lock()
+	b, ok := sf.bundles[key]
+	sf.mu.Unlock()
+	if ok && time.Now().Before(b.renewAt) {
+		return b, fs.OK
+	}
+
+	spath := parts[0] + "/issue/" + parts[1]
+	data := map[string]interface{}{
+		"common_name": parts[2],
+	}
+	if viper.IsSet("fio.pki.ttl") {
+		data["ttl"] = viper.GetString("fio.pki.ttl")
+	}
+	resp, err := store.WriteVault(spath, data, ctx)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"spath":      spath,
+			"commonname": parts[2],
+			"key":        key,
+			"error":      err}).Error("got error while issuing certificate")
+		return nil, syscall.EACCES
+	}
+	b, err = newPKIBundle(resp, viper.GetFloat64("fio.pki.renewfraction"))
+	if err != nil {
+		log.WithFields(log.Fields{
+			"spath":      spath,
+			"commonname": parts[2],
+			"error":      err}).Error("got error while reading issued certificate")
+		return nil, syscall.EIO
+	}
+	log.WithFields(log.Fields{
+		"spath":      spath,
+		"commonname": parts[2],
+		"key":        key,
+		"renewat":    b.renewAt}).Info("issued certificate")
+	return sf.storeBundle(key, b, time.Now()), fs.OK
+}
+
+// storeBundle caches b for key and drops all expired bundles. If another
+// bundle was cached for key in the meantime and isn't due for renewal yet, the
+// cached one is kept and returned, so that concurrent opens share a
+// certificate.
+func (sf *FIOPKI) storeBundle(key string, b *pkiBundle, now time.Time) *pkiBundle {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	for k, cached := range sf.bundles {
+		if !now.Before(cached.notAfter) {
+			delete(sf.bundles, k)
+		}
+	}
+	if cached, ok := sf.bundles[key]; ok && now.Before(cached.renewAt) {
+		return cached
+	}
+	sf.bundles[key] = b
+	return b
+}
+
+// issuedCommonNames returns the common names of all bundles cached for the
+// calling user below parts (mount, role)
+func (sf *FIOPKI) issuedCommonNames(ctx context.Context, parts []string) []string {
+	u, err := sfsfh.GetUserFromContext(ctx)
+	if err != nil {
+		return nil
+	}
+	prefix := u.Uid + ":" + strings.Join(parts, "/") + "/"
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	var cns []string
+	for k, b := range sf.bundles {
+		if strings.HasPrefix(k, prefix) && time.Now().Before(b.notAfter) {
+			cns = append(cns, strings.TrimPrefix(k, prefix))
+		}
+	}
+	return cns
+}
+
+// newPKIBundle creates a bundle from the data returned by vault. The bundle is
+// due for renewal after fraction of the certificate's lifetime has elapsed.
+func newPKIBundle(data map[string]interface{}, fraction float64) (*pkiBundle, error) {
+	b := &pkiBundle{files: make(map[string][]byte)}
+	for name, k := range pkiFiles {
+		b.files[name] = []byte(store.ValueToString(data[k]) + "\n")
+	}
+	block, _ := pem.Decode(b.files["cert.pem"])
+	if block == nil {
+		return nil, fmt.Errorf("msg=\"could not decode certificate\"\n")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	if fraction <= 0 || fraction > 1 {
+		fraction = 1
+	}
+	lifetime := cert.NotAfter.Sub(cert.NotBefore)
+	b.renewAt = cert.NotBefore.Add(time.Duration(float64(lifetime) * fraction))
+	b.notAfter = cert.NotAfter
+	return b, nil
+}
+
+// isPKIMount checks whether mount is configured in fio.pki.mounts
+func isPKIMount(mount string) bool {
+	for _, m := range viper.GetStringSlice("fio.pki.mounts") {
+		if m == mount {
+			return true
+		}
+	}
+	return false
+}
+
+func init() {
+	fioroot := FIOPKI{
+		bundles: make(map[string]*pkiBundle),
+	}
+	fm := FIOMap{
+		Root: &fioroot,
+	}
+	RegisterRoot(&fm)
+}
@@ -0,0 +1,90 @@
+package secretsfs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestNewPKIBundle(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	notBefore := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		NotBefore:    notBefore,
+		NotAfter:     notBefore.Add(100 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	data := map[string]interface{}{
+		"certificate": cert,
+		"issuing_ca":  cert,
+		"private_key": "key",
+	}
+
+	tables := []struct {
+		fraction float64
+		renewAt  time.Time
+	}{
+		{0.5, notBefore.Add(50 * time.Hour)},
+		{0.75, notBefore.Add(75 * time.Hour)},
+		{0, notBefore.Add(100 * time.Hour)},
+		{2, notBefore.Add(100 * time.Hour)},
+	}
+	for _, table := range tables {
+		b, err := newPKIBundle(data, table.fraction)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !b.renewAt.Equal(table.renewAt) {
+			t.Errorf("renewAt for fraction '%v' was incorrect, got: '%v', want: '%v'\n", table.fraction, b.renewAt, table.renewAt)
+		}
+		if !b.notAfter.Equal(notBefore.Add(100 * time.Hour)) {
+			t.Errorf("notAfter was incorrect, got: '%v'\n", b.notAfter)
+		}
+		if string(b.files["key.pem"]) != "key\n" {
+			t.Errorf("key.pem was incorrect, got: '%v'\n", string(b.files["key.pem"]))
+		}
+	}
+
+	if _, err := newPKIBundle(map[string]interface{}{}, 0.5); err == nil {
+		t.Errorf("expected error for missing certificate\n")
+	}
+}
+
+func TestStoreBundle(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	sf := &FIOPKI{bundles: map[string]*pkiBundle{
+		"1000:pki/web/expired.example.com": {renewAt: now.Add(-2 * time.Hour), notAfter: now.Add(-time.Hour)},
+		"1000:pki/web/due.example.com":     {renewAt: now.Add(-time.Hour), notAfter: now.Add(time.Hour)},
+		"1000:pki/web/valid.example.com":   {renewAt: now.Add(time.Hour), notAfter: now.Add(2 * time.Hour)},
+	}}
+
+	due := &pkiBundle{renewAt: now.Add(time.Hour), notAfter: now.Add(2 * time.Hour)}
+	if got := sf.storeBundle("1000:pki/web/due.example.com", due, now); got != due {
+		t.Errorf("bundle due for renewal was not replaced\n")
+	}
+	concurrent := &pkiBundle{renewAt: now.Add(time.Hour), notAfter: now.Add(2 * time.Hour)}
+	if got := sf.storeBundle("1000:pki/web/valid.example.com", concurrent, now); got == concurrent {
+		t.Errorf("valid cached bundle was replaced\n")
+	}
+	if _, ok := sf.bundles["1000:pki/web/expired.example.com"]; ok {
+		t.Errorf("expired bundle was not dropped\n")
+	}
+	if len(sf.bundles) != 2 {
+		t.Errorf("number of cached bundles was incorrect, got: '%v', want: '%v'\n", len(sf.bundles), 2)
+	}
+}
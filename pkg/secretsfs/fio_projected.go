@@ -0,0 +1,441 @@
+package secretsfs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+
+	sfsfh "github.com/muryoutaisuu/secretsfs/pkg/fusehelpers"
+	"github.com/muryoutaisuu/secretsfs/pkg/store"
+)
+
+// FIOProjected shows secrets like kubernetes projected volumes, so that all
+// keys of a secret change atomically. Following directory structure is
+// implemented for every secret containing keys:
+//   	projected/
+//   	└── <path in store>
+//   	  	├── ..2026_10_18_12_00_00.000000000
+//   	  	│   ├── password
+//   	  	│   └── username
+//   	  	├── ..data -> ..2026_10_18_12_00_00.000000000
+//   	  	├── password -> ..data/password
+//   	  	├── username -> ..data/username
+//   	  	└── <subdirectory>
+// The keys are read from the store at once and kept as snapshot per user.
+// After fio.projected.refresh the secret is read again. If any key changed, a
+// new timestamped snapshot directory is created and ..data points to it.
+// Snapshots not accessed within fio.projected.expiry are dropped.
+// If the secret can't be read again, its snapshots are dropped, unless the
+// error is transient and the last successful read is less than
+// fio.projected.maxstale ago.
+type FIOProjected struct {
+	mu          sync.Mutex
+	projections map[string]*projection
+}
+
+// projection contains the snapshots of a secret for one user. The previous
+// snapshot is kept, so that consumers who resolved ..data right before a
+// rotation can still read it.
+type projection struct {
+	current  *projectedSnapshot
+	previous *projectedSnapshot
+	checked  time.Time
+	accessed time.Time
+}
+
+// projectedSnapshot contains all keys of a secret at a point in time. It is
+// never modified after creation.
+type projectedSnapshot struct {
+	name    string
+	created time.Time
+	files   map[string][]byte
+}
+
+// kinds of entries shown by FIOProjected
+const (
+	projectedDir = iota
+	projectedSnapshotDir
+	projectedDataLink
+	projectedKeyLink
+	projectedFile
+)
+
+// projectedDataName is the name of the symlink pointing to the current
+// snapshot
+const projectedDataName = "..data"
+
+// projectedEntry describes an entry of FIOProjected
+type projectedEntry struct {
+	kind     int
+	snapshot *projectedSnapshot
+	key      string
+}
+
+var _ = (FIORoot)((*FIOProjected)(nil))
+var _ = (FIOReadlinker)((*FIOProjected)(nil))
+
+func (sf *FIOProjected) Readdir(n *SfsNode, ctx context.Context) (out fs.DirStream, errno syscall.Errno) {
+	log.WithFields(log.Fields{"n": n, "n.npath": n.npath}).Debug("log values")
+
+	_, secpath := rootName(n.npath)
+	e, errno := sf.getEntry(ctx, secpath)
+	if errno != fs.OK {
+		return nil, errno
+	}
+
+	var direntries []fuse.DirEntry
+	switch e.kind {
+	case projectedSnapshotDir:
+		for _, k := range sortedByteKeys(e.snapshot.files) {
+			direntries = append(direntries, getDirEntry(n.npath, k, fuse.S_IFREG))
+		}
+	case projectedDir:
+		sec, err := (*store.GetStore()).GetSecret(secpath, ctx)
+		if err != nil {
+			log.WithFields(log.Fields{"secpath": secpath, "error": err, "calling": "GetSecret(secpath, ctx)"}).Error("Got error while getting secret")
+			return nil, syscall.ENOENT
+		}
+		for _, v := range sec.Subs {
+			if sfsfh.IsDir(v.Mode) {
+				direntries = append(direntries, getDirEntry(n.npath, filepath.Base(v.Path), fuse.S_IFDIR))
+			}
+		}
+		if current, _, errno := sf.getSnapshots(ctx, secpath); errno == fs.OK {
+			direntries = append(direntries,
+				getDirEntry(n.npath, current.name, fuse.S_IFDIR),
+				getDirEntry(n.npath, projectedDataName, fuse.S_IFLNK))
+			for _, k := range sortedByteKeys(current.files) {
+				direntries = append(direntries, getDirEntry(n.npath, k, fuse.S_IFLNK))
+			}
+		}
+	default:
+		return nil, syscall.ENOTDIR
+	}
+	log.WithFields(log.Fields{"direntries": direntries}).Debug("log values")
+	return fs.NewListDirStream(direntries), fs.OK
+}
+
+func (sf *FIOProjected) Lookup(n *SfsNode, ctx context.Context, name string, out *fuse.EntryOut) (node *fs.Inode, errno syscall.Errno) {
+	log.WithFields(log.Fields{
+		"n":          n,
+		"n.npath":    n.npath,
+		"name":       name,
+		"out.NodeId": out.NodeId}).Debug("log values")
+
+	prefixedfullname := filepath.Join(n.npath, name)
+	_, secpath := rootName(prefixedfullname)
+	e, errno := sf.getEntry(ctx, secpath)
+	if errno != fs.OK {
+		return nil, errno
+	}
+	var mode uint32 = fuse.S_IFDIR
+	switch e.kind {
+	case projectedDataLink, projectedKeyLink:
+		mode = fuse.S_IFLNK
+	case projectedFile:
+		mode = fuse.S_IFREG
+	}
+	return getLookupChild(n, prefixedfullname, mode, ctx, out)
+}
+
+func (sf *FIOProjected) Open(n *SfsNode, ctx context.Context, flags uint32) (fh fs.FileHandle, fuseFlags uint32, errno syscall.Errno) {
+	log.WithFields(log.Fields{"n": n, "n.npath": n.npath}).Debug("log values")
+	_, secpath := rootName(n.npath)
+	e, errno := sf.getEntry(ctx, secpath)
+	if errno != fs.OK {
+		return nil, 0, errno
+	}
+	if e.kind != projectedFile {
+		return nil, 0, syscall.EISDIR
+	}
+	// the snapshot is used as file handle, so that opened files stay readable
+	// even after the snapshot has been rotated out
+	return e.snapshot, fuse.FOPEN_DIRECT_IO, fs.OK
+}
+
+func (sf *FIOProjected) Read(n *SfsNode, ctx context.Context, f fs.FileHandle, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
+	log.WithFields(log.Fields{"n": n, "n.npath": n.npath}).Debug("log values")
+	key := filepath.Base(n.npath)
+	s, ok := f.(*projectedSnapshot)
+	if !ok {
+		_, secpath := rootName(n.npath)
+		e, errno := sf.getEntry(ctx, secpath)
+		if errno != fs.OK {
+			return nil, errno
+		}
+		if e.kind != projectedFile {
+			return nil, syscall.EISDIR
+		}
+		s = e.snapshot
+	}
+	content, ok := s.files[key]
+	if !ok {
+		return nil, syscall.ENOENT
+	}
+	return readResultAt(content, dest, off), fs.OK
+}
+
+func (sf *FIOProjected) Getattr(n *SfsNode, ctx context.Context, fh fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	log.WithFields(log.Fields{"n": n, "n.npath": n.npath}).Debug("log values")
+	out.Ino = GetInode(n.npath)
+	if IsRootPath(n.npath) {
+		return fs.OK
+	}
+	_, secpath := rootName(n.npath)
+	e, errno := sf.getEntry(ctx, secpath)
+	if errno != fs.OK {
+		return errno
+	}
+	switch e.kind {
+	case projectedFile:
+		out.Size = uint64(len(e.snapshot.files[e.key]))
+	case projectedDataLink, projectedKeyLink:
+		out.Size = uint64(len(e.target()))
+	}
+	if e.snapshot != nil {
+		out.Mtime = uint64(e.snapshot.created.Unix())
+		out.Mtimensec = uint32(e.snapshot.created.Nanosecond())
+	}
+	return fs.OK
+}
+
+func (sf *FIOProjected) Readlink(n *SfsNode, ctx context.Context) ([]byte, syscall.Errno) {
+	log.WithFields(log.Fields{"n": n, "n.npath": n.npath}).Debug("log values")
+	_, secpath := rootName(n.npath)
+	e, errno := sf.getEntry(ctx, secpath)
+	if errno != fs.OK {
+		return nil, errno
+	}
+	if e.kind != projectedDataLink && e.kind != projectedKeyLink {
+		return nil, syscall.EINVAL
+	}
+	return []byte(e.target()), fs.OK
+}
+
+func (sf *FIOProjected) FIOPath() string {
+	return "projected"
+}
+
+// target returns the target of symlink entries
+func (e *projectedEntry) target() string {
+	if e.kind == projectedDataLink {
+		return e.snapshot.name
+	}
+	return filepath.Join(projectedDataName, e.key)
+}
+
+// getEntry returns the entry at secpath for the calling user
+func (sf *FIOProjected) getEntry(ctx context.Context, secpath string) (*projectedEntry, syscall.Errno) {
+	if secpath == "" {
+		return &projectedEntry{kind: projectedDir}, fs.OK
+	}
+	dir, name := parentPath(secpath), filepath.Base(secpath)
+
+	// files inside of a snapshot directory
+	if base := filepath.Base(dir); dir != "" && isSnapshotName(base) {
+		current, previous, errno := sf.getSnapshots(ctx, parentPath(dir))
+		if errno != fs.OK {
+			return nil, errno
+		}
+		for _, s := range []*projectedSnapshot{current, previous} {
+			if s == nil || s.name != base {
+				continue
+			}
+			if _, ok := s.files[name]; ok {
+				return &projectedEntry{kind: projectedFile, snapshot: s, key: name}, fs.OK
+			}
+		}
+		return nil, syscall.ENOENT
+	}
+
+	if strings.HasPrefix(name, "..") {
+		current, previous, errno := sf.getSnapshots(ctx, dir)
+		if errno != fs.OK {
+			return nil, errno
+		}
+		if name == projectedDataName {
+			return &projectedEntry{kind: projectedDataLink, snapshot: current}, fs.OK
+		}
+		for _, s := range []*projectedSnapshot{current, previous} {
+			if s != nil && s.name == name {
+				return &projectedEntry{kind: projectedSnapshotDir, snapshot: s}, fs.OK
+			}
+		}
+		return nil, syscall.ENOENT
+	}
+
+	// keys of the parent secret are shown as symlinks, which shadow
+	// subdirectories of the same name
+	if current, _, errno := sf.getSnapshots(ctx, dir); errno == fs.OK {
+		if _, ok := current.files[name]; ok {
+			return &projectedEntry{kind: projectedKeyLink, snapshot: current, key: name}, fs.OK
+		}
+	}
+	sec, err := (*store.GetStore()).GetSecret(secpath, ctx)
+	if err != nil || !sfsfh.IsDir(sec.Mode) {
+		return nil, syscall.ENOENT
+	}
+	return &projectedEntry{kind: projectedDir}, fs.OK
+}
+
+// getSnapshots returns the current and previous snapshot of the secret at
+// spath for the calling user. If fio.projected.refresh has elapsed since the
+// last check, the secret is read again and rotated if it changed. Reading
+// happens without holding sf.mu, so that users don't wait on each other's
+// requests to the store.
+func (sf *FIOProjected) getSnapshots(ctx context.Context, spath string) (current, previous *projectedSnapshot, errno syscall.Errno) {
+	u, err := sfsfh.GetUserFromContext(ctx)
+	if err != nil {
+		log.WithFields(log.Fields{"spath": spath, "error": err}).Error("got error while getting user from context")
+		return nil, nil, syscall.EACCES
+	}
+	key := u.Uid + ":" + spath
+
+	sf.mu.Lock()
+	if p, ok := sf.projections[key]; ok {
+		p.accessed = time.Now()
+		if time.Since(p.checked) < viper.GetDuration("fio.projected.refresh") {
+			current, previous = p.current, p.previous
+			sf.mu.Unlock()
+			return current, previous, fs.OK
+		}
+	}
+	sf.mu.Unlock()
+
+	data, err := store.GetSecretData(*store.GetStore(), spath, ctx)
+
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	now := time.Now()
+	if err != nil {
+		log.WithFields(log.Fields{"spath": spath, "username": u.Username, "error": err}).Debug("got error while reading secret")
+		return sf.refreshFailed(key, err, now)
+	}
+	p, ok := sf.projections[key]
+	if !ok {
+		sf.prune(now)
+		p = &projection{accessed: now}
+		sf.projections[key] = p
+	}
+	if p.update(data, now) {
+		log.WithFields(log.Fields{"spath": spath, "username": u.Username, "snapshot": p.current.name}).Info("rotated projected secret")
+	}
+	return p.current, p.previous, fs.OK
+}
+
+// refreshFailed handles the error err of reading the secret of the projection
+// at key. Secrets which vanished or may not be read anymore are dropped. On
+// other errors, e.g. an unreachable vault, the last snapshots are served, so
+// that consumers don't see a secret vanish, but at most for
+// fio.projected.maxstale since the last successful read. sf.mu must be held.
+func (sf *FIOProjected) refreshFailed(key string, err error, now time.Time) (current, previous *projectedSnapshot, errno syscall.Errno) {
+	p, ok := sf.projections[key]
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		delete(sf.projections, key)
+		return nil, nil, syscall.ENOENT
+	case errors.Is(err, os.ErrPermission):
+		delete(sf.projections, key)
+		return nil, nil, syscall.EACCES
+	case ok && now.Sub(p.checked) < viper.GetDuration("fio.projected.maxstale"):
+		log.WithFields(log.Fields{"key": key, "checked": p.checked, "error": err}).Warn("serving stale projected secret, as it could not be read again")
+		return p.current, p.previous, fs.OK
+	}
+	delete(sf.projections, key)
+	return nil, nil, syscall.EIO
+}
+
+// prune drops all projections not accessed within fio.projected.expiry.
+// sf.mu must be held.
+func (sf *FIOProjected) prune(now time.Time) {
+	expiry := viper.GetDuration("fio.projected.expiry")
+	if expiry <= 0 {
+		return
+	}
+	for k, p := range sf.projections {
+		if now.Sub(p.accessed) >= expiry {
+			delete(sf.projections, k)
+		}
+	}
+}
+
+// update checks data against the current snapshot. If any key changed, a new
+// snapshot created at now becomes the current one and true is returned.
+func (p *projection) update(data map[string]interface{}, now time.Time) bool {
+	p.checked = now
+	files := make(map[string][]byte, len(data))
+	for k, v := range data {
+		files[k] = []byte(store.ValueToString(v))
+	}
+	if p.current != nil && sameFiles(p.current.files, files) {
+		return false
+	}
+	name := now.Format("..2006_01_02_15_04_05.000000000")
+	if p.current != nil && name == p.current.name {
+		// snapshot names must never be reused for different content
+		now = now.Add(time.Nanosecond)
+		name = now.Format("..2006_01_02_15_04_05.000000000")
+	}
+	p.previous = p.current
+	p.current = &projectedSnapshot{name: name, created: now, files: files}
+	return true
+}
+
+// sameFiles checks whether a and b contain the same files with equal content
+func sameFiles(a, b map[string][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if w, ok := b[k]; !ok || !bytes.Equal(v, w) {
+			return false
+		}
+	}
+	return true
+}
+
+// isSnapshotName checks whether name is the name of a snapshot directory
+func isSnapshotName(name string) bool {
+	return strings.HasPrefix(name, "..") && name != projectedDataName
+}
+
+// parentPath returns the parent of secpath, "" for the root
+func parentPath(secpath string) string {
+	dir := filepath.Dir(secpath)
+	if dir == "." {
+		return ""
+	}
+	return dir
+}
+
+// sortedByteKeys returns the keys of m in sorted order
+func sortedByteKeys(m map[string][]byte) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func init() {
+	fioroot := FIOProjected{
+		projections: make(map[string]*projection),
+	}
+	fm := FIOMap{
+		Root: &fioroot,
+	}
+	RegisterRoot(&fm)
+}
@@ -0,0 +1,111 @@
+package secretsfs
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/spf13/viper"
+
+	"github.com/muryoutaisuu/secretsfs/pkg/store"
+)
+
+func TestProjectionUpdate(t *testing.T) {
+	p := &projection{}
+	first := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	tables := []struct {
+		data    map[string]interface{}
+		now     time.Time
+		rotated bool
+		name    string
+	}{
+		{map[string]interface{}{"username": "admin", "password": "a"}, first, true, "..2026_10_18_12_00_00.000000000"},
+		{map[string]interface{}{"username": "admin", "password": "a"}, first.Add(time.Minute), false, "..2026_10_18_12_00_00.000000000"},
+		{map[string]interface{}{"username": "admin", "password": "b"}, first.Add(time.Minute), true, "..2026_10_18_12_01_00.000000000"},
+		{map[string]interface{}{"username": "admin"}, first.Add(time.Minute), true, "..2026_10_18_12_01_00.000000001"},
+	}
+
+	for _, table := range tables {
+		previous := p.current
+		rotated := p.update(table.data, table.now)
+		if rotated != table.rotated {
+			t.Errorf("rotation for '%v' was incorrect, got: '%v', want: '%v'\n", table.data, rotated, table.rotated)
+		}
+		if p.current.name != table.name {
+			t.Errorf("snapshot name for '%v' was incorrect, got: '%v', want: '%v'\n", table.data, p.current.name, table.name)
+		}
+		if rotated && p.previous != previous {
+			t.Errorf("previous snapshot for '%v' was not kept\n", table.data)
+		}
+		if len(p.current.files) != len(table.data) {
+			t.Errorf("Not the correct amount of files in snapshot for '%v'!\nGot:  %v\n", table.data, p.current.files)
+		}
+	}
+}
+
+func TestProjectedEntryTarget(t *testing.T) {
+	s := &projectedSnapshot{name: "..2026_10_18_12_00_00.000000000"}
+	tables := []struct {
+		e    *projectedEntry
+		want string
+	}{
+		{&projectedEntry{kind: projectedDataLink, snapshot: s}, "..2026_10_18_12_00_00.000000000"},
+		{&projectedEntry{kind: projectedKeyLink, snapshot: s, key: "password"}, "..data/password"},
+	}
+
+	for _, table := range tables {
+		if got := table.e.target(); got != table.want {
+			t.Errorf("target was incorrect, got: '%v', want: '%v'\n", got, table.want)
+		}
+	}
+}
+
+func TestProjectedPrune(t *testing.T) {
+	viper.Set("fio.projected.expiry", "1h")
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	sf := &FIOProjected{projections: map[string]*projection{
+		"1000:app/db":  {accessed: now.Add(-2 * time.Hour)},
+		"1000:app/web": {accessed: now.Add(-time.Minute)},
+	}}
+	sf.prune(now)
+	if _, ok := sf.projections["1000:app/db"]; ok {
+		t.Errorf("expired projection was not dropped\n")
+	}
+	if _, ok := sf.projections["1000:app/web"]; !ok {
+		t.Errorf("accessed projection was dropped\n")
+	}
+}
+
+func TestProjectedRefreshFailed(t *testing.T) {
+	viper.Set("fio.projected.maxstale", "5m")
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	unavailable := errors.New("vault unavailable")
+	tables := []struct {
+		err     error
+		checked time.Time
+		errno   syscall.Errno
+		kept    bool
+	}{
+		{fmt.Errorf("msg=\"%w\"", store.ErrNotFound), now.Add(-time.Minute), syscall.ENOENT, false},
+		{&os.PathError{Op: "open", Path: "app/db", Err: syscall.EACCES}, now.Add(-time.Minute), syscall.EACCES, false},
+		{unavailable, now.Add(-time.Minute), fs.OK, true},
+		{unavailable, now.Add(-10 * time.Minute), syscall.EIO, false},
+	}
+	for _, table := range tables {
+		current := &projectedSnapshot{name: "..2026_10_18_11_59_00.000000000"}
+		sf := &FIOProjected{projections: map[string]*projection{
+			"1000:app/db": {current: current, checked: table.checked},
+		}}
+		got, _, errno := sf.refreshFailed("1000:app/db", table.err, now)
+		if errno != table.errno {
+			t.Errorf("errno for '%v' was incorrect, got: '%v', want: '%v'\n", table.err, errno, table.errno)
+		}
+		if _, ok := sf.projections["1000:app/db"]; ok != table.kept || (table.kept && got != current) {
+			t.Errorf("projection for '%v' was kept incorrectly, got: '%v', want: '%v'\n", table.err, ok, table.kept)
+		}
+	}
+}
@@ -4,11 +4,13 @@ import (
 	"context"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"syscall"
 
 	"github.com/hanwen/go-fuse/v2/fs"
 	"github.com/hanwen/go-fuse/v2/fuse"
 	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
 
 	sfsfh "github.com/muryoutaisuu/secretsfs/pkg/fusehelpers" //SecretsFS FuseHelper
 	"github.com/muryoutaisuu/secretsfs/pkg/store"
@@ -23,9 +25,14 @@ func (sf *FIOSecretsFiles) Readdir(n *SfsNode, ctx context.Context) (out fs.DirS
 
 	sto := *store.GetStore()
 	_, secpath := rootName(n.npath)
-	sec, err := sto.GetSecret(secpath, ctx)
+	spath, err := sf.storePath(secpath, ctx)
 	if err != nil {
-		log.WithFields(log.Fields{"secpath": secpath, "error": err, "calling": "sto.GetSecret(secpath, ctx)"}).Error("Got error while getting secret")
+		log.WithFields(log.Fields{"secpath": secpath, "error": err, "calling": "sf.storePath(secpath, ctx)"}).Error("Got error while resolving path mapping")
+		return nil, syscall.ENOENT
+	}
+	sec, err := sto.GetSecret(spath, ctx)
+	if err != nil {
+		log.WithFields(log.Fields{"secpath": secpath, "spath": spath, "error": err, "calling": "sto.GetSecret(spath, ctx)"}).Error("Got error while getting secret")
 		return nil, syscall.ENOENT
 	}
 	if !sfsfh.IsDir(sec.Mode) {
@@ -35,9 +42,19 @@ func (sf *FIOSecretsFiles) Readdir(n *SfsNode, ctx context.Context) (out fs.DirS
 
 	var direntries []fuse.DirEntry
 
+	// add path mappings to the root directory
+	if secpath == "" {
+		for name := range viper.GetStringMapString("fio.secretsfiles.pathmappings") {
+			direntries = append(direntries, getDirEntry(n.npath, name, fuse.S_IFDIR))
+			if tarArchives() {
+				direntries = append(direntries, getDirEntry(n.npath, name+tarSuffix, fuse.S_IFREG))
+			}
+		}
+	}
+
 	log.Println("logging subs")
 	for _, v := range sec.Subs {
-		fixedpath := sf.prefixPath(v.Path)
+		fixedpath := filepath.Join(n.npath, filepath.Base(v.Path))
 		log.WithFields(log.Fields{
 			"v.Path":    v.Path,
 			"v.Mode":    strconv.FormatInt(int64(v.Mode), 16),
@@ -49,6 +66,22 @@ func (sf *FIOSecretsFiles) Readdir(n *SfsNode, ctx context.Context) (out fs.DirS
 			Ino:  GetInode(fixedpath),
 			Mode: uint32(v.Mode),
 		})
+		// every directory may also be read as tar archive
+		if sfsfh.IsDir(v.Mode) && tarArchives() {
+			direntries = append(direntries, getDirEntry(n.npath, filepath.Base(fixedpath)+tarSuffix, fuse.S_IFREG))
+		}
+	}
+
+	// add files rendering the whole secret, if it contains any keys
+	for _, v := range sec.Subs {
+		if sfsfh.IsFile(v.Mode) {
+			for _, f := range viper.GetStringSlice("fio.secretsfiles.formats") {
+				if _, ok := secretFormats[f]; ok {
+					direntries = append(direntries, getDirEntry(n.npath, "."+f, fuse.S_IFREG))
+				}
+			}
+			break
+		}
 	}
 	log.WithFields(log.Fields{"direntries": direntries}).Debug("log values")
 	return fs.NewListDirStream(direntries), fs.OK
@@ -65,17 +98,32 @@ func (sf *FIOSecretsFiles) Lookup(n *SfsNode, ctx context.Context, name string,
 	sto := *store.GetStore()
 	_, secpath := rootName(n.npath)
 	fullname := filepath.Join(secpath, name)
-	sec, err := sto.GetSecret(fullname, ctx)
+	if _, ok := getSecretFormat(name); ok {
+		if !sf.isDir(secpath, ctx) {
+			return nil, syscall.ENOENT
+		}
+		return getLookupChild(n, sf.prefixPath(fullname), fuse.S_IFREG, ctx, out)
+	}
+	if _, ok := sf.tarDir(fullname, ctx); ok {
+		return getLookupChild(n, sf.prefixPath(fullname), fuse.S_IFREG, ctx, out)
+	}
+	spath, err := sf.storePath(fullname, ctx)
+	if err != nil {
+		log.WithFields(log.Fields{"fullname": fullname, "error": err, "calling": "sf.storePath(fullname, ctx)"}).Error("Got error while resolving path mapping")
+		return nil, syscall.ENOENT
+	}
+	sec, err := sto.GetSecret(spath, ctx)
 	if err != nil {
 		log.WithFields(log.Fields{
-			"calling":  "sto.GetSecret(fullname, ctx)",
+			"calling":  "sto.GetSecret(spath, ctx)",
 			"fullname": fullname,
+			"spath":    spath,
 			"n":        n,
 			"n.npath":  n.npath,
 			"name":     name,
 			"error":    err}).Warn("got error while getting secret, probably not enough permissions")
 		//return nil, syscall.EPERM
-		sec = &store.Secret{Path: fullname, Mode: sfsfh.DIRNOREAD, Content: "", Subs: nil}
+		sec = &store.Secret{Path: fullname, Mode: sfsfh.DIRNOREAD, Content: nil, Subs: nil}
 	}
 	prefixedfullname := sf.prefixPath(fullname)
 	log.WithFields(log.Fields{"inode": GetInode(prefixedfullname), "mode": strconv.FormatInt(int64(sec.Mode), 16)}).Debug("log values")
@@ -97,20 +145,55 @@ func (sf *FIOSecretsFiles) Open(n *SfsNode, ctx context.Context, flags uint32) (
 		"n":       n,
 		"n.npath": n.npath,
 		"flags":   strconv.FormatInt(int64(flags), 16)}).Debug("log values")
+	// content of mapped paths depends on the calling user, so the kernel must
+	// not serve it from its cache to other users
+	_, secpath := rootName(n.npath)
+	if spath, ok := sf.tarDir(secpath, ctx); ok {
+		// the archive depends on the calling user's permissions and is
+		// generated while it is read
+		return newTarStream(*store.GetStore(), spath, strings.TrimSuffix(filepath.Base(secpath), tarSuffix)), fuse.FOPEN_DIRECT_IO, 0
+	}
+	if sf.isMapped(secpath) {
+		return nil, fuse.FOPEN_DIRECT_IO, 0
+	}
 	return nil, 0, 0
 }
 
 func (sf *FIOSecretsFiles) Read(n *SfsNode, ctx context.Context, f fs.FileHandle, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
 	log.WithFields(log.Fields{"n": n, "n.npath": n.npath}).Debug("log values")
 
+	if h, ok := f.(*tarStream); ok {
+		content, err := h.readAt(off, len(dest), ctx)
+		if err != nil {
+			log.WithFields(log.Fields{"calling": "h.readAt(off, len(dest), ctx)", "n.npath": n.npath, "error": err}).Error("got error while creating tar archive")
+			return nil, syscall.EIO
+		}
+		return fuse.ReadResultData(content), fs.OK
+	}
 	sto := *store.GetStore()
 	_, secpath := rootName(n.npath)
-	sec, err := sto.GetSecret(secpath, ctx)
+	if format, ok := getSecretFormat(filepath.Base(secpath)); ok {
+		content, err := sf.renderFormat(filepath.Dir(secpath), format, ctx)
+		if err != nil {
+			log.WithFields(log.Fields{"calling": "sf.renderFormat(filepath.Dir(secpath), format, ctx)", "secpath": secpath, "error": err}).Error("got error while rendering secret")
+			return nil, syscall.ENOENT
+		}
+		return readResultAt(content, dest, off), fs.OK
+	}
+	spath, err := sf.storePath(secpath, ctx)
+	if err != nil {
+		log.WithFields(log.Fields{"calling": "sf.storePath(secpath, ctx)", "secpath": secpath, "error": err}).Error("got error while resolving path mapping")
+		return nil, syscall.ENOENT
+	}
+	sec, err := sto.GetSecret(spath, ctx)
 	if err != nil {
-		log.WithFields(log.Fields{"calling": "sto.GetSecret(secpath, ctx)", "secpath": secpath, "error": err}).Error("got error while getting secret")
+		log.WithFields(log.Fields{"calling": "sto.GetSecret(spath, ctx)", "secpath": secpath, "spath": spath, "error": err}).Error("got error while getting secret")
 		return nil, syscall.ENOENT
 	}
-	results := fuse.ReadResultData([]byte(sec.Content))
+	templateCache.secretSeen(spath, fingerprint(sec.Content))
+	// mapped paths are opened with FOPEN_DIRECT_IO, so the kernel doesn't
+	// clip reads at the file size
+	results := readResultAt(sec.Content, dest, off)
 	log.WithFields(log.Fields{"results": results}).Debug("log values")
 	return results, fs.OK
 }
@@ -129,15 +212,38 @@ func (sf *FIOSecretsFiles) Getattr(n *SfsNode, ctx context.Context, fh fs.FileHa
 
 	sto := *store.GetStore()
 	_, secpath := rootName(n.npath)
-	sec, err := sto.GetSecret(secpath, ctx)
+	if format, ok := getSecretFormat(filepath.Base(secpath)); ok {
+		content, err := sf.renderFormat(filepath.Dir(secpath), format, ctx)
+		if err != nil {
+			log.WithFields(log.Fields{"calling": "sf.renderFormat(filepath.Dir(secpath), format, ctx)", "secpath": secpath, "error": err}).Warn("got error while rendering secret, probably not enough permissions")
+			return syscall.ENOENT
+		}
+		out.Size = uint64(len(content))
+		out.Ino = GetInode(n.npath)
+		return fs.OK
+	}
+	if _, ok := sf.tarDir(secpath, ctx); ok {
+		// the size is only known after generating the whole archive, so it
+		// is shown as 0. archives are opened with FOPEN_DIRECT_IO, so they
+		// are read until their end anyway
+		out.Ino = GetInode(n.npath)
+		return fs.OK
+	}
+	spath, err := sf.storePath(secpath, ctx)
+	if err != nil {
+		log.WithFields(log.Fields{"calling": "sf.storePath(secpath, ctx)", "secpath": secpath, "error": err}).Error("got error while resolving path mapping")
+		return syscall.ENOENT
+	}
+	sec, err := sto.GetSecret(spath, ctx)
 	if err != nil {
 		log.WithFields(log.Fields{
-			"calling": "sto.GetSecret(secpath, ctx)",
+			"calling": "sto.GetSecret(spath, ctx)",
 			"secpath": secpath,
+			"spath":   spath,
 			"n":       n,
 			"n.npath": n.npath,
 			"error":   err}).Warn("got error while getting secret, probably not enough permissions")
-		sec = &store.Secret{Path: secpath, Mode: sfsfh.FILENOREAD, Content: "", Subs: nil}
+		sec = &store.Secret{Path: secpath, Mode: sfsfh.FILENOREAD, Content: nil, Subs: nil}
 		//return syscall.ENOENT
 	}
 	log.WithFields(log.Fields{"inode": GetInode(n.npath), "Mode": strconv.FormatInt(int64(sec.Mode), 16)}).Debug("log values")
@@ -157,6 +263,89 @@ func (sf *FIOSecretsFiles) prefixPath(npath string) string {
 	return string(filepath.Separator) + filepath.Join(sf.FIOPath(), npath)
 }
 
+// renderFormat renders all keys of the secret at secpath in format
+func (sf *FIOSecretsFiles) renderFormat(secpath, format string, ctx context.Context) ([]byte, error) {
+	if secpath == "." {
+		secpath = ""
+	}
+	spath, err := sf.storePath(secpath, ctx)
+	if err != nil {
+		return nil, err
+	}
+	data, err := store.GetSecretData(*store.GetStore(), spath, ctx)
+	if err != nil {
+		return nil, err
+	}
+	return renderSecretFormat(data, format)
+}
+
+// isDir checks whether secpath is a directory in the store
+func (sf *FIOSecretsFiles) isDir(secpath string, ctx context.Context) bool {
+	spath, err := sf.storePath(secpath, ctx)
+	if err != nil {
+		return false
+	}
+	sec, err := (*store.GetStore()).GetSecret(spath, ctx)
+	return err == nil && sfsfh.IsDir(sec.Mode)
+}
+
+// tarDir returns the store path of the directory archived by the tar file at
+// secpath. Keys named like the tar file take precedence.
+func (sf *FIOSecretsFiles) tarDir(secpath string, ctx context.Context) (string, bool) {
+	if !tarArchives() || !strings.HasSuffix(secpath, tarSuffix) || filepath.Base(secpath) == tarSuffix {
+		return "", false
+	}
+	sto := *store.GetStore()
+	if spath, err := sf.storePath(secpath, ctx); err == nil {
+		if _, err := sto.GetSecret(spath, ctx); err == nil {
+			return "", false
+		}
+	}
+	spath, err := sf.storePath(strings.TrimSuffix(secpath, tarSuffix), ctx)
+	if err != nil {
+		return "", false
+	}
+	sec, err := sto.GetSecret(spath, ctx)
+	if err != nil || !sfsfh.IsDir(sec.Mode) {
+		return "", false
+	}
+	return spath, true
+}
+
+// tarArchives checks whether directories are accompanied by their tar archive,
+// configured in fio.secretsfiles.tararchives
+func tarArchives() bool {
+	return viper.GetBool("fio.secretsfiles.tararchives")
+}
+
+// storePath returns the path of secpath in the store. If the first element of
+// secpath is configured in fio.secretsfiles.pathmappings, it is replaced by the
+// mapping resolved for the calling user.
+func (sf *FIOSecretsFiles) storePath(secpath string, ctx context.Context) (string, error) {
+	list := strings.SplitN(secpath, string(filepath.Separator), 2)
+	tmpl, ok := viper.GetStringMapString("fio.secretsfiles.pathmappings")[list[0]]
+	if !ok {
+		return secpath, nil
+	}
+	pu, err := newPathUser(ctx)
+	if err != nil {
+		return "", err
+	}
+	mapped, err := renderPathTemplate(tmpl, pu)
+	if err != nil {
+		return "", err
+	}
+	list[0] = mapped
+	return filepath.Join(list...), nil
+}
+
+// isMapped checks whether secpath is below a path mapping
+func (sf *FIOSecretsFiles) isMapped(secpath string) bool {
+	list := strings.SplitN(secpath, string(filepath.Separator), 2)
+	_, ok := viper.GetStringMapString("fio.secretsfiles.pathmappings")[list[0]]
+	return ok
+}
+
 func init() {
 	fioroot := FIOSecretsFiles{}
 	fm := FIOMap{
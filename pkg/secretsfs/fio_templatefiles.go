@@ -3,42 +3,154 @@ package secretsfs
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"syscall"
 	"text/template"
+	"time"
 
 	"github.com/hanwen/go-fuse/v2/fs"
 	"github.com/hanwen/go-fuse/v2/fuse"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 
+	sfsfh "github.com/muryoutaisuu/secretsfs/pkg/fusehelpers"
 	"github.com/muryoutaisuu/secretsfs/pkg/store"
 )
 
 var TEMPLATESPATHS map[string]string
 
+const (
+	// partialsDir is the directory of partials in every templatespath
+	partialsDir = "_partials"
+	// partialSuffix marks files as partials anywhere in a templatespath
+	partialSuffix = ".tpl"
+)
+
 // secret will be used to call the stores implementation of all the needed FUSE-
 // operations together with the provided flags and fuse.Context.
 type secret struct {
 	ctx *context.Context
+	// refs records the values of all secrets referenced while rendering, see
+	// renderCache
+	refs map[string]string
+	// files records the modification times of partials and the directories
+	// searched for them while rendering, see renderCache
+	files map[string]time.Time
+	// allowed contains the path prefixes the template may reference, all
+	// paths are allowed if it is nil
+	allowed []string
+}
+
+// check returns the cleaned spath, if the template may reference it
+func (s secret) check(spath string) (string, error) {
+	spath = strings.Trim(path.Clean("/"+spath), "/")
+	if s.allowed == nil {
+		return spath, nil
+	}
+	for _, prefix := range s.allowed {
+		prefix = strings.Trim(path.Clean("/"+prefix), "/")
+		if prefix == "" || spath == prefix || strings.HasPrefix(spath, prefix+"/") {
+			return spath, nil
+		}
+	}
+	return "", fmt.Errorf("msg=\"secret is not in the allowed paths of the templatespath\" secret=\"%v\"\n", spath)
+}
+
+// reference records value as seen for ref
+func (s secret) reference(ref string, value []byte) {
+	if s.refs != nil {
+		s.refs[ref] = fingerprint(value)
+	}
 }
 
 // Get is the function that will be called from inside of the templatefile.
 // You need to use following scheme to get secrets substituted:
 //  {{ .Get "path/to/secret" }}
 func (s secret) Get(filepath string) (string, error) {
+	filepath, err := s.check(filepath)
+	if err != nil {
+		return "", err
+	}
 	sto := *store.GetStore()
 	sec, err := sto.GetSecret(filepath, *s.ctx)
 	if err != nil {
 		return "", err
 	}
-	if sec.Content == "" {
+	if len(sec.Content) == 0 {
 		return "", fmt.Errorf("msg=\"content of secret is empty\" secret=\"%v\"\n", filepath)
 	}
-	return sec.Content, nil
+	s.reference(filepath, sec.Content)
+	return string(sec.Content), nil
+}
+
+// GetMap returns all keys of the secret at filepath with their values, e.g.
+//  {{ range $k, $v := .GetMap "app/db" }}{{ $k }}={{ $v }}{{ end }}
+// Structured values are returned as JSON.
+func (s secret) GetMap(filepath string) (map[string]string, error) {
+	filepath, err := s.check(filepath)
+	if err != nil {
+		return nil, err
+	}
+	data, err := store.GetSecretData(*store.GetStore(), filepath, *s.ctx)
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string]string, len(data))
+	for k, v := range data {
+		m[k] = store.ValueToString(v)
+		s.reference(path.Join(filepath, k), []byte(m[k]))
+	}
+	return m, nil
+}
+
+// List returns the sorted names of all children of the directory at
+// filepath, e.g.
+//  {{ range .List "apps" }}[{{ . }}]{{ end }}
+func (s secret) List(filepath string) ([]string, error) {
+	filepath, err := s.check(filepath)
+	if err != nil {
+		return nil, err
+	}
+	sec, err := (*store.GetStore()).GetSecret(filepath, *s.ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !sfsfh.IsDir(sec.Mode) {
+		return nil, fmt.Errorf("msg=\"secret is not a directory\" secret=\"%v\"\n", filepath)
+	}
+	names := make([]string, 0, len(sec.Subs))
+	for _, sub := range sec.Subs {
+		names = append(names, path.Base(sub.Path))
+	}
+	sort.Strings(names)
+	s.reference("list:"+filepath, []byte(strings.Join(names, "\n")))
+	return names, nil
+}
+
+// Exists checks whether the secret at filepath exists and may be read by the
+// calling user, e.g.
+//  {{ if .Exists "app/feature" }}feature = on{{ end }}
+// Other errors of the store, e.g. an unreachable vault, fail the rendering.
+func (s secret) Exists(filepath string) (bool, error) {
+	filepath, err := s.check(filepath)
+	if err != nil {
+		return false, err
+	}
+	_, err = (*store.GetStore()).GetSecret(filepath, *s.ctx)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		return false, err
+	}
+	s.reference("exists:"+filepath, []byte(strconv.FormatBool(err == nil)))
+	return err == nil, nil
 }
 
 type FIOTemplateFiles struct{}
@@ -67,17 +179,21 @@ func (sf *FIOTemplateFiles) Readdir(n *SfsNode, ctx context.Context) (out fs.Dir
 		// walk unixpaths and return their dir listings
 	} else if templp, ok := TEMPLATESPATHS[rtemplp]; ok {
 		unixpath := filepath.Join(templp, utemplp)
-		files, err := ioutil.ReadDir(unixpath)
+		entries, err := getTemplateEntries(unixpath, utemplp)
 		if err != nil {
 			log.WithFields(log.Fields{"unixpath": unixpath, "templp": templp, "utemplp": utemplp, "error": err}).Error("got error while reading dir contents of templatepath")
 			return nil, syscall.ENOENT
 		}
-		for _, f := range files {
+		for name, e := range entries {
 			direntries = append(direntries, fuse.DirEntry{
-				Name: f.Name(),
-				Ino:  GetInode(filepath.Join(n.npath, f.Name())),
-				Mode: getModeFromFileInfo(f),
+				Name: name,
+				Ino:  GetInode(filepath.Join(n.npath, name)),
+				Mode: getModeFromFileInfo(e.info),
 			})
+			// show the last render error of the calling user
+			if _, ok := entries[name+renderErrorSuffix]; !ok && e.hasRenderError(ctx) {
+				direntries = append(direntries, getDirEntry(n.npath, name+renderErrorSuffix, fuse.S_IFREG))
+			}
 		}
 	} else {
 		return nil, syscall.ENOSYS
@@ -96,7 +212,10 @@ func (sf *FIOTemplateFiles) Lookup(n *SfsNode, ctx context.Context, name string,
 
 	prefixedfullname := filepath.Join(n.npath, name)
 	// if is root template path, then
-	if _, ok := TEMPLATESPATHS[name]; ok {
+	if _, ok := TEMPLATESPATHS[name]; ok && IsRootPath(n.npath) {
+		if errno := checkTemplateAccess(name, ctx); errno != fs.OK {
+			return nil, errno
+		}
 		return getLookupChild(n, prefixedfullname, fuse.S_IFDIR, ctx, out)
 	}
 
@@ -104,16 +223,26 @@ func (sf *FIOTemplateFiles) Lookup(n *SfsNode, ctx context.Context, name string,
 	rtemplp, utemplp := getTemplateSubPaths(n.npath) // roottemplatepath + unixtemplatepath
 	if templp, ok := TEMPLATESPATHS[rtemplp]; ok {
 		unixpath := filepath.Join(templp, utemplp)
-		files, err := ioutil.ReadDir(unixpath)
+		entries, err := getTemplateEntries(unixpath, utemplp)
 		if err != nil {
 			log.WithFields(log.Fields{"unixpath": unixpath, "templp": templp, "utemplp": utemplp, "error": err}).Error("got error while reading dir contents of templatepath")
 			return nil, syscall.ENOENT
 		}
-		for _, f := range files {
-			// if upath listing contains the requested filename
-			if f.Name() == name {
-				return getLookupChild(n, prefixedfullname, getModeFromFileInfo(f), ctx, out)
+		// if upath listing contains the requested filename
+		if e, ok := entries[name]; ok {
+			if errno := checkTemplateAccess(filepath.Join(rtemplp, utemplp, name), ctx); errno != fs.OK {
+				return nil, errno
+			}
+			if errno := e.checkGroups(ctx); errno != fs.OK {
+				return nil, errno
+			}
+			return getLookupChild(n, prefixedfullname, getModeFromFileInfo(e.info), ctx, out)
+		}
+		if e, ok := entries[strings.TrimSuffix(name, renderErrorSuffix)]; ok && e.hasRenderError(ctx) {
+			if errno := e.checkAccess(filepath.Join(rtemplp, utemplp, strings.TrimSuffix(name, renderErrorSuffix)), ctx); errno != fs.OK {
+				return nil, errno
 			}
+			return getLookupChild(n, prefixedfullname, fuse.S_IFREG, ctx, out)
 		}
 	}
 	return nil, syscall.ENOENT
@@ -127,14 +256,31 @@ func (sf *FIOTemplateFiles) Read(n *SfsNode, ctx context.Context, f fs.FileHandl
 	log.WithFields(log.Fields{"n": n, "n.npath": n.npath}).Debug("log values")
 
 	rtemplp, utemplp := getTemplateSubPaths(n.npath) // roottemplatepath + unixtemplatepath
+	if message, ok, errno := getRenderError(rtemplp, utemplp, ctx); ok {
+		if errno != fs.OK {
+			return nil, errno
+		}
+		return readResultAt(message, dest, off), fs.OK
+	}
 	if templp, ok := TEMPLATESPATHS[rtemplp]; ok {
-		unixpath := filepath.Join(templp, utemplp)
+		// check access before any secret is fetched
+		if errno := checkTemplateAccess(filepath.Join(rtemplp, utemplp), ctx); errno != fs.OK {
+			return nil, errno
+		}
+		e, errno := resolveTemplateEntry(templp, utemplp)
+		if errno != fs.OK {
+			return nil, errno
+		}
+		if errno := e.checkGroups(ctx); errno != fs.OK {
+			return nil, errno
+		}
+		unixpath := e.unixpath
 		log.WithFields(log.Fields{
 			"rtemplp":  rtemplp,
 			"utemplp":  utemplp,
 			"templp":   templp,
 			"unixpath": unixpath}).Debug("log values")
-		content, err := renderTemplatefile(unixpath, &ctx)
+		content, err := renderTemplatefile(rtemplp, unixpath, allowedPaths("templatefiles", rtemplp), &ctx)
 		if err != nil {
 			log.WithFields(log.Fields{
 				"rtemplp":  rtemplp,
@@ -169,35 +315,46 @@ func (sf *FIOTemplateFiles) Getattr(n *SfsNode, ctx context.Context, fh fs.FileH
 		return fs.OK
 	}
 
+	if message, ok, errno := getRenderError(rtemplp, utemplp, ctx); ok {
+		if errno != fs.OK {
+			return errno
+		}
+		out.Size = uint64(len(message))
+		out.Ino = GetInode(n.npath)
+		return fs.OK
+	}
+
 	// walk unixpath and lstat on requested file
 	log.WithFields(log.Fields{
 		"rtemplp":                 rtemplp,
 		"utemplp":                 utemplp,
 		"TEMPLATESPATHS[rtemplp]": TEMPLATESPATHS[rtemplp]}).Debug("log values")
 	if templp, ok := TEMPLATESPATHS[rtemplp]; ok {
-		unixpath := filepath.Join(templp, utemplp)
-		log.Printf("unixpath=\"%v\"\n", unixpath)
+		e, errno := resolveTemplateEntry(templp, utemplp)
+		if errno != fs.OK {
+			return errno
+		}
+		unixpath := e.unixpath
 		log.WithFields(log.Fields{
 			"rtemplp":                 rtemplp,
 			"utemplp":                 utemplp,
 			"TEMPLATESPATHS[rtemplp]": TEMPLATESPATHS[rtemplp],
 			"unixpath":                unixpath}).Debug("log values")
-		fileinfo, err := os.Stat(unixpath)
-		if err != nil {
-			log.WithFields(log.Fields{
-				"rtemplp":                 rtemplp,
-				"utemplp":                 utemplp,
-				"TEMPLATESPATHS[rtemplp]": TEMPLATESPATHS[rtemplp],
-				"unixpath":                unixpath,
-				"error":                   err}).Error("got error while performing os.Stat(unixpath)")
-			return syscall.ENOENT
-		}
-		if fileinfo.Mode().IsRegular() {
-			content, err := renderTemplatefile(unixpath, &ctx)
+		if e.info.Mode().IsRegular() {
+			if errno := checkTemplateAccess(filepath.Join(rtemplp, utemplp), ctx); errno != fs.OK {
+				return errno
+			}
+			if errno := e.checkGroups(ctx); errno != fs.OK {
+				return errno
+			}
+			content, err := renderTemplatefile(rtemplp, unixpath, allowedPaths("templatefiles", rtemplp), &ctx)
 			if err != nil {
 				log.WithFields(log.Fields{"error": err}).Error("error while rendering templatefile for size calculation")
 			}
 			out.Size = uint64(len(content))
+			if perm, _ := e.fm.perm(); perm != 0 {
+				out.Mode = perm
+			}
 		}
 		out.Ino = GetInode(n.npath)
 		return fs.OK
@@ -205,6 +362,30 @@ func (sf *FIOTemplateFiles) Getattr(n *SfsNode, ctx context.Context, fh fs.FileH
 	return syscall.ENOENT
 }
 
+var _ = (FIOXattrer)((*FIOTemplateFiles)(nil))
+
+// Xattrs shows the content type declared in the front matter as
+// user.mime_type
+func (sf *FIOTemplateFiles) Xattrs(n *SfsNode, ctx context.Context) (map[string][]byte, syscall.Errno) {
+	rtemplp, utemplp := getTemplateSubPaths(n.npath)
+	templp, ok := TEMPLATESPATHS[rtemplp]
+	if IsRootPath(n.npath) || !ok {
+		return nil, fs.OK
+	}
+	if _, ok, errno := getRenderError(rtemplp, utemplp, ctx); ok {
+		return nil, errno
+	}
+	e, errno := resolveTemplateEntry(templp, utemplp)
+	if errno != fs.OK {
+		return nil, errno
+	}
+	xattrs := make(map[string][]byte)
+	if e.fm.ContentType != "" {
+		xattrs["user.mime_type"] = []byte(e.fm.ContentType)
+	}
+	return xattrs, fs.OK
+}
+
 func (sf *FIOTemplateFiles) FIOPath() string {
 	return "templatefiles"
 }
@@ -213,6 +394,134 @@ func (sf *FIOTemplateFiles) prefixPath(npath string) string {
 	return string(filepath.Separator) + filepath.Join(sf.FIOPath(), npath)
 }
 
+// templateEntry is a file or directory in a templatespath
+type templateEntry struct {
+	unixpath string
+	info     os.FileInfo
+	fm       *frontMatter
+}
+
+// getTemplateEntries returns the entries of unixdir by their shown names,
+// which may be declared in the front matter. utempldir is unixdir relative to
+// its templatespath.
+func getTemplateEntries(unixdir, utempldir string) (map[string]*templateEntry, error) {
+	files, err := ioutil.ReadDir(unixdir)
+	if err != nil {
+		return nil, err
+	}
+	entries := make(map[string]*templateEntry)
+	for _, f := range files {
+		if isPartial(filepath.Join(utempldir, f.Name())) {
+			continue
+		}
+		e := &templateEntry{unixpath: filepath.Join(unixdir, f.Name()), info: f, fm: &frontMatter{}}
+		if f.Mode().IsRegular() {
+			fm, err := readFrontMatter(e.unixpath)
+			if err != nil {
+				log.WithFields(log.Fields{"unixpath": e.unixpath, "error": err}).Warn("ignoring front matter of templatefile")
+			} else {
+				e.fm = fm
+			}
+		}
+		name := e.fm.displayName(f.Name())
+		// declared names take precedence over names of files
+		if existing, ok := entries[name]; ok && existing.fm.Name == name {
+			continue
+		}
+		entries[name] = e
+	}
+	return entries, nil
+}
+
+// resolveTemplateEntry returns the entry shown at utemplp in the templatespath
+// templp. Only files may be renamed, so all parents exist on disk as they are.
+func resolveTemplateEntry(templp, utemplp string) (*templateEntry, syscall.Errno) {
+	if utemplp == "" {
+		info, err := os.Stat(templp)
+		if err != nil {
+			return nil, syscall.ENOENT
+		}
+		return &templateEntry{unixpath: templp, info: info, fm: &frontMatter{}}, fs.OK
+	}
+	dir := parentPath(utemplp)
+	entries, err := getTemplateEntries(filepath.Join(templp, dir), dir)
+	if err != nil {
+		log.WithFields(log.Fields{"templp": templp, "utemplp": utemplp, "error": err}).Error("got error while reading dir contents of templatepath")
+		return nil, syscall.ENOENT
+	}
+	e, ok := entries[filepath.Base(utemplp)]
+	if !ok {
+		return nil, syscall.ENOENT
+	}
+	return e, fs.OK
+}
+
+// checkGroups checks whether the calling user is member of one of the groups
+// declared in the front matter
+func (e *templateEntry) checkGroups(ctx context.Context) syscall.Errno {
+	if len(e.fm.Groups) == 0 {
+		return fs.OK
+	}
+	pu, err := newPathUser(ctx)
+	if err != nil || !e.fm.permits(pu) {
+		log.WithFields(log.Fields{"unixpath": e.unixpath, "groups": e.fm.Groups, "error": err}).Warn("calling user is not member of the groups required by templatefile")
+		return syscall.EACCES
+	}
+	return fs.OK
+}
+
+// checkAccess checks the ACLs applying to tpath, the path of e below
+// templatefiles/, and the groups of its front matter for the calling user
+func (e *templateEntry) checkAccess(tpath string, ctx context.Context) syscall.Errno {
+	if errno := checkTemplateAccess(tpath, ctx); errno != fs.OK {
+		return errno
+	}
+	return e.checkGroups(ctx)
+}
+
+// hasRenderError checks whether rendering e failed for the calling user
+func (e *templateEntry) hasRenderError(ctx context.Context) bool {
+	if !e.info.Mode().IsRegular() {
+		return false
+	}
+	key, ok := newRenderErrorKey(e.unixpath, ctx)
+	if !ok {
+		return false
+	}
+	_, ok = templateErrors.get(key)
+	return ok
+}
+
+// getRenderError returns the last render error of the calling user, if
+// utemplp is the error file of a templatefile and no entry itself. The
+// calling user must have access to the templatefile, otherwise ok is true
+// and errno is set.
+func getRenderError(rtemplp, utemplp string, ctx context.Context) (message []byte, ok bool, errno syscall.Errno) {
+	templp, ok := TEMPLATESPATHS[rtemplp]
+	if !ok || !strings.HasSuffix(utemplp, renderErrorSuffix) {
+		return nil, false, fs.OK
+	}
+	if _, errno := resolveTemplateEntry(templp, utemplp); errno == fs.OK {
+		return nil, false, fs.OK
+	}
+	utemplp = strings.TrimSuffix(utemplp, renderErrorSuffix)
+	e, errno := resolveTemplateEntry(templp, utemplp)
+	if errno != fs.OK {
+		return nil, false, fs.OK
+	}
+	key, ok := newRenderErrorKey(e.unixpath, ctx)
+	if !ok {
+		return nil, false, fs.OK
+	}
+	if message, ok = templateErrors.get(key); !ok {
+		return nil, false, fs.OK
+	}
+	if errno := e.checkAccess(filepath.Join(rtemplp, utemplp), ctx); errno != fs.OK {
+		return nil, true, errno
+	}
+	return message, true, fs.OK
+}
+
 func getTemplateSubPaths(npath string) (rtemplp, utemplp string) {
 	_, spath := rootName(npath) // rpath + spath   == rootpath + subpath
 	return rootName(spath)      // roottemplatepath + unixtemplatepath
@@ -230,8 +539,130 @@ func getLookupChild(n *SfsNode, name string, mode uint32, ctx context.Context, o
 	return child, fs.OK
 }
 
+// isPartial checks whether utemplp, relative to its templatespath, is a
+// partial, which is not shown in the mount
+func isPartial(utemplp string) bool {
+	parts := strings.Split(filepath.Clean(utemplp), string(filepath.Separator))
+	return parts[0] == partialsDir || filepath.Ext(utemplp) == partialSuffix
+}
+
+// parsePartials adds all partials below root to tmpl. Partials are named by
+// their path relative to the partials directory or to root, without
+// extension, e.g. _partials/tls.tpl and tls.tpl are both named tls.
+// The modification times of all partials and searched directories are
+// recorded in files, if it isn't nil.
+func parsePartials(tmpl *template.Template, root string, files map[string]time.Time) error {
+	snapshot, err := templatePartials.get(root)
+	if err != nil {
+		return err
+	}
+	for _, p := range snapshot.partials {
+		if _, err := tmpl.New(p.name).Parse(p.content); err != nil {
+			return fmt.Errorf("msg=\"Got an error while parsing partial\" filepath=\"%s\" error=\"%v\"\n", p.path, err)
+		}
+	}
+	if files != nil {
+		for p, mtime := range snapshot.files {
+			files[p] = mtime
+		}
+	}
+	return nil
+}
+
+// partial is a partial read from a templatespath
+type partial struct {
+	path    string
+	name    string
+	content string
+}
+
+// partialsSnapshot contains all partials of a templatespath
+type partialsSnapshot struct {
+	partials []partial
+	// files maps the partials and all directories of the templatespath to
+	// their modification times, so that modified, added or removed partials
+	// are noticed
+	files map[string]time.Time
+}
+
+// partialsCache caches the partials per templatespath, so that rendering
+// doesn't search the whole templatespath every time
+type partialsCache struct {
+	mu    sync.Mutex
+	roots map[string]*partialsSnapshot
+}
+
+var templatePartials = &partialsCache{roots: make(map[string]*partialsSnapshot)}
+
+// get returns the partials below root, which are read again if any of them
+// changed
+func (c *partialsCache) get(root string) (*partialsSnapshot, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if snapshot, ok := c.roots[root]; ok && !filesChanged(snapshot.files) {
+		return snapshot, nil
+	}
+	snapshot, err := readPartials(root)
+	if err != nil {
+		delete(c.roots, root)
+		return nil, err
+	}
+	c.roots[root] = snapshot
+	return snapshot, nil
+}
+
+// readPartials reads all partials below root
+func readPartials(root string) (*partialsSnapshot, error) {
+	snapshot := &partialsSnapshot{files: make(map[string]time.Time)}
+	err := filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			snapshot.files[p] = info.ModTime()
+		}
+		if !info.Mode().IsRegular() || !isPartial(rel) {
+			return nil
+		}
+		snapshot.files[p] = info.ModTime()
+		if parts := strings.SplitN(rel, string(filepath.Separator), 2); parts[0] == partialsDir && len(parts) == 2 {
+			rel = parts[1]
+		}
+		content, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		name := strings.TrimSuffix(filepath.ToSlash(rel), filepath.Ext(rel))
+		snapshot.partials = append(snapshot.partials, partial{path: p, name: name, content: string(content)})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// filesChanged checks whether any of files was modified or removed since its
+// modification time was recorded
+func filesChanged(files map[string]time.Time) bool {
+	for p, mtime := range files {
+		if info, err := os.Lstat(p); err != nil || !info.ModTime().Equal(mtime) {
+			return true
+		}
+	}
+	return false
+}
+
+// renderTemplatefile renders the template at tpath in the templatespath
+// named rtemplp. Partials of the templatespath apply, if rtemplp isn't empty.
+// The template may only reference the store paths in allowed, see
+// secret.allowed.
 // tpath = templatepath
-func renderTemplatefile(tpath string, context *context.Context) ([]byte, error) {
+func renderTemplatefile(rtemplp, tpath string, allowed []string, context *context.Context) ([]byte, error) {
 	// check whether filepath exists
 	fileinfo, err := os.Stat(tpath)
 	if err != nil {
@@ -244,9 +675,151 @@ func renderTemplatefile(tpath string, context *context.Context) ([]byte, error)
 		log.WithFields(log.Fields{"tpath": tpath}).Error("file is not a regular file, can not render templatefile")
 		return nil, fmt.Errorf(fmt.Sprintf("%s is not a file", tpath))
 	}
+	f, err := os.Open(tpath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return renderOpenedTemplatefile(rtemplp, tpath, f, allowed, context)
+}
 
+// renderOpenedTemplatefile renders the templatefile at tpath like
+// renderTemplatefile, but reads it from f, e.g. opened on behalf of the calling
+// user, so that the file rendered is the one whose permissions were checked.
+func renderOpenedTemplatefile(rtemplp, tpath string, f *os.File, allowed []string, context *context.Context) ([]byte, error) {
+	fileinfo, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if !fileinfo.Mode().IsRegular() {
+		return nil, fmt.Errorf("msg=\"not a regular file, can not render templatefile\" tpath=\"%v\"\n", tpath)
+	}
+
+	key, cacheable := newRenderCacheKey(tpath, fileinfo.ModTime(), *context)
+	if cacheable {
+		if content, ok := templateCache.get(key, time.Now()); ok {
+			log.WithFields(log.Fields{"tpath": tpath, "uid": key.uid}).Debug("serving rendered templatefile from cache")
+			return content, nil
+		}
+	}
+	thesecret := secret{
+		ctx:     context,
+		refs:    make(map[string]string),
+		files:   make(map[string]time.Time),
+		allowed: allowed,
+	}
+	var root string
+	if rtemplp != "" {
+		root = TEMPLATESPATHS[rtemplp]
+	}
+	raw, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	content, err := executeTemplate(root, tpath, raw, thesecret)
+	ekey, identified := newRenderErrorKey(tpath, *context)
+	if identified && rtemplp != "" {
+		var message []byte
+		if err != nil {
+			rel, _ := filepath.Rel(root, tpath)
+			message = redactRenderError(filepath.Join(rtemplp, rel), tpath, err)
+		}
+		templateErrors.set(ekey, message)
+	}
+	lkg := identified && viper.GetBool("fio.templatefiles.lastknowngood")
+	var verr *outputValidationError
+	if err != nil && lkg && errors.As(err, &verr) {
+		if good, ok := templateLastKnownGood.get(ekey, time.Now()); ok {
+			log.WithFields(log.Fields{"tpath": tpath, "uid": ekey.uid, "error": err}).Warn("serving last known good output of templatefile")
+			return good, nil
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if lkg {
+		templateLastKnownGood.set(ekey, content, time.Now(), viper.GetDuration("fio.templatefiles.lastknowngoodttl"))
+	}
+	// values seen now invalidate renders of other templates seeing old values
+	for ref, fp := range thesecret.refs {
+		templateCache.secretSeen(ref, fp)
+	}
+	if cacheable {
+		templateCache.put(key, content, thesecret.refs, thesecret.files, time.Now(), viper.GetDuration("fio.templatefiles.cachettl"))
+	}
+	return content, nil
+}
+
+// allowedPaths returns the store paths the templates in the directory name of
+// the FIO fio may reference, as declared in fio.<fio>.allowedpaths. nil allows
+// all paths, if the directory has no entry, while an empty entry allows none.
+func allowedPaths(fio, name string) []string {
+	allowed, ok := viper.GetStringMapStringSlice("fio." + fio + ".allowedpaths")[name]
+	if !ok {
+		return nil
+	}
+	return append([]string{}, allowed...)
+}
+
+// warnUnrestricted warns about the directories in names, which have no entry
+// in fio.<fio>.allowedpaths, as their templates may reference every secret
+func warnUnrestricted(fio string, names []string) {
+	allowed := viper.GetStringMapStringSlice("fio." + fio + ".allowedpaths")
+	for _, name := range names {
+		if _, ok := allowed[name]; !ok {
+			log.WithFields(log.Fields{"fio": fio, "name": name}).Warn("no entry in fio." + fio + ".allowedpaths, templates may reference every secret the reading user has access to")
+		}
+	}
+}
+
+// executeTemplatefile executes the template at tpath with thesecret as data,
+// using the engine declared in its front matter or by its extension
+func executeTemplatefile(root, tpath string, thesecret secret) ([]byte, error) {
+	raw, err := ioutil.ReadFile(tpath)
+	if err != nil {
+		return nil, err
+	}
+	return executeTemplate(root, tpath, raw, thesecret)
+}
+
+// executeTemplate executes raw, the content of the templatefile at tpath, see
+// executeTemplatefile
+func executeTemplate(root, tpath string, raw []byte, thesecret secret) ([]byte, error) {
+	fm, body, lines, err := parseFrontMatter(raw)
+	if err != nil {
+		return nil, err
+	}
+	name := selectTemplateEngine(tpath, fm)
+	engine, ok := templateEngines[name]
+	if !ok {
+		return nil, fmt.Errorf("msg=\"unknown template engine\" filepath=\"%s\" engine=\"%s\"\n", tpath, name)
+	}
+	content, err := engine(root, tpath, body, lines, thesecret)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateOutput(fm.Format, content); err != nil {
+		return nil, err
+	}
+	return content, nil
+}
+
+// executeGoTemplate is the default engine, executing body as go template
+func executeGoTemplate(root, tpath string, body []byte, lines int, thesecret secret) ([]byte, error) {
 	filename := filepath.Base(tpath)
-	parser, err := template.New(filename).ParseFiles(tpath)
+	parser := template.New(filename).Funcs(templateFuncs)
+	// include works like template, but returns the result for pipelines
+	parser.Funcs(template.FuncMap{"include": func(name string, data interface{}) (string, error) {
+		var buf bytes.Buffer
+		err := parser.ExecuteTemplate(&buf, name, data)
+		return buf.String(), err
+	}})
+	if root != "" {
+		if err := parsePartials(parser, root, thesecret.files); err != nil {
+			return nil, err
+		}
+	}
+	_, err := parser.Parse(string(padTemplate(body, lines)))
 	// error handling
 	if err != nil {
 		return nil, fmt.Errorf("msg=\"Got an error while getting template\" filepath=\"%s\" filename=\"%s\" error=\"%v\"\n", tpath, filename, err)
@@ -257,10 +830,6 @@ func renderTemplatefile(tpath string, context *context.Context) ([]byte, error)
 	// https://gowalker.org/bytes#Buffer_Bytes
 	// https://stackoverflow.com/questions/23454940/getting-bytes-buffer-does-not-implement-io-writer-error-message
 	var buf bytes.Buffer
-	thesecret := secret{
-		ctx: context,
-	}
-
 	err = parser.Execute(&buf, thesecret)
 	if err != nil {
 		return nil, err
@@ -279,4 +848,11 @@ func init() {
 	}
 	RegisterRoot(&fm)
 	generateTemplatesPaths()
+	if fm.Enabled {
+		var names []string
+		for name := range TEMPLATESPATHS {
+			names = append(names, name)
+		}
+		warnUnrestricted("templatefiles", names)
+	}
 }
@@ -0,0 +1,194 @@
+package secretsfs
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+
+	sfsfh "github.com/muryoutaisuu/secretsfs/pkg/fusehelpers"
+	"github.com/muryoutaisuu/secretsfs/pkg/store"
+)
+
+func TestSecretMethods(t *testing.T) {
+	sto := store.Map{
+		"apps": {Path: "apps", Mode: sfsfh.DIRREAD, Subs: []*store.Secret{
+			{Path: "apps/web", Mode: sfsfh.DIRREAD},
+			{Path: "apps/db", Mode: sfsfh.DIRREAD},
+		}},
+		"apps/db": {Path: "apps/db", Mode: sfsfh.DIRREAD, Subs: []*store.Secret{
+			{Path: "apps/db/user", Mode: sfsfh.FILEREAD},
+			{Path: "apps/db/password", Mode: sfsfh.FILEREAD},
+		}},
+		"apps/db/user":     {Path: "apps/db/user", Mode: sfsfh.FILEREAD, Content: []byte("admin")},
+		"apps/db/password": {Path: "apps/db/password", Mode: sfsfh.FILEREAD, Content: []byte("secret")},
+	}
+	active := store.GetStore()
+	previous := *active
+	*active = sto
+	defer func() { *active = previous }()
+
+	ctx := context.Background()
+	tables := []struct {
+		tmpl string
+		want string
+	}{
+		{`{{ range $k, $v := .GetMap "apps/db" }}{{ $k }}={{ $v }};{{ end }}`, "password=secret;user=admin;"},
+		{`{{ range .List "apps" }}[{{ . }}]{{ end }}`, "[db][web]"},
+		{`{{ if .Exists "apps/db/user" }}yes{{ end }}{{ if .Exists "apps/db/missing" }}no{{ end }}`, "yes"},
+	}
+	for _, table := range tables {
+		got, err := executeTestTemplate(table.tmpl, secret{ctx: &ctx})
+		if err != nil {
+			t.Errorf("got error for template '%v': %v\n", table.tmpl, err)
+			continue
+		}
+		if got != table.want {
+			t.Errorf("template '%v' was incorrect, got: '%v', want: '%v'\n", table.tmpl, got, table.want)
+		}
+	}
+	if _, err := executeTestTemplate(`{{ .List "apps/db/user" }}`, secret{ctx: &ctx}); err == nil {
+		t.Errorf("expected error when listing a key\n")
+	}
+
+	// only missing secrets don't exist, other errors of the store fail rendering
+	*active = failingStore{}
+	if _, err := executeTestTemplate(`{{ if .Exists "apps/db/user" }}yes{{ end }}`, secret{ctx: &ctx}); err == nil {
+		t.Errorf("expected error when the store fails\n")
+	}
+	*active = sto
+
+	// templates may only reference the allowed paths of their templatespath
+	restricted := secret{ctx: &ctx, allowed: []string{"apps/db/"}}
+	allowed := []struct {
+		tmpl    string
+		allowed bool
+	}{
+		{`{{ .Get "apps/db/user" }}`, true},
+		{`{{ .GetMap "apps/db" }}`, true},
+		{`{{ .Get "apps/dbx/user" }}`, false},
+		{`{{ .Get "apps/db/../web/user" }}`, false},
+		{`{{ .List "apps" }}`, false},
+		{`{{ .Exists "apps/web" }}`, false},
+	}
+	for _, table := range allowed {
+		if _, err := executeTestTemplate(table.tmpl, restricted); (err == nil) != table.allowed {
+			t.Errorf("template '%v' was allowed incorrectly, got error: '%v', want allowed: '%v'\n", table.tmpl, err, table.allowed)
+		}
+	}
+
+	// templatespaths without entry may reference all secrets, while an empty
+	// entry allows none
+	viper.Set("fio.templatefiles.allowedpaths", map[string][]string{"apps": {"apps/"}, "all": {"/"}, "none": {}})
+	defer viper.Set("fio.templatefiles.allowedpaths", map[string][]string{})
+	if _, err := executeTestTemplate(`{{ .Get "apps/db/user" }}`, secret{ctx: &ctx, allowed: allowedPaths("templatefiles", "none")}); err == nil {
+		t.Errorf("templatespath with empty entry in allowedpaths referenced a secret\n")
+	}
+	for _, name := range []string{"apps", "all", "other"} {
+		if _, err := executeTestTemplate(`{{ .Get "apps/db/user" }}`, secret{ctx: &ctx, allowed: allowedPaths("templatefiles", name)}); err != nil {
+			t.Errorf("templatespath '%v' couldn't reference allowed secret, got error: '%v'\n", name, err)
+		}
+	}
+}
+
+func TestRenderTemplatefilePartials(t *testing.T) {
+	root, err := ioutil.TempDir("", "templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	files := map[string]string{
+		"_partials/tls.tpl":  "cert = {{ . }}.pem",
+		"_partials/db/x.tpl": `{{ define "dsn" }}postgres://{{ . }}{{ end }}`,
+		"footer.tpl":         "# end",
+		"app.conf":           "{{ template \"tls\" \"app\" }}\n{{ include \"db/x\" . }}{{ template \"dsn\" \"db\" }}\n{{ include \"tls\" \"x\" | upper }}\n{{ template \"footer\" }}",
+	}
+	for name, content := range files {
+		p := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	previous := TEMPLATESPATHS
+	TEMPLATESPATHS = map[string]string{"test": root}
+	defer func() { TEMPLATESPATHS = previous }()
+
+	ctx := context.Background()
+	got, err := renderTemplatefile("test", filepath.Join(root, "app.conf"), nil, &ctx)
+	if err != nil {
+		t.Fatalf("got error while rendering: %v\n", err)
+	}
+	want := "cert = app.pem\npostgres://db\nCERT = X.PEM\n# end"
+	if string(got) != want {
+		t.Errorf("rendered template was incorrect, got: '%v', want: '%v'\n", string(got), want)
+	}
+
+	tables := []struct {
+		utemplp string
+		partial bool
+	}{
+		{"app.conf", false},
+		{"footer.tpl", true},
+		{"_partials", true},
+		{"_partials/db/x.tpl", true},
+		{"sub/_partials", false},
+		{"sub/header.tpl", true},
+	}
+	for _, table := range tables {
+		if got := isPartial(table.utemplp); got != table.partial {
+			t.Errorf("isPartial(%v) was incorrect, got: '%v', want: '%v'\n", table.utemplp, got, table.partial)
+		}
+	}
+}
+
+func TestPartialsCache(t *testing.T) {
+	root, err := ioutil.TempDir("", "templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	if err := ioutil.WriteFile(filepath.Join(root, "tls.tpl"), []byte("tls"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c := &partialsCache{roots: make(map[string]*partialsSnapshot)}
+	first, err := c.get(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// unchanged partials are not read again
+	if again, _ := c.get(root); again != first {
+		t.Errorf("unchanged partials were read again\n")
+	}
+	// added partials are noticed by the modification time of their directory
+	if err := ioutil.WriteFile(filepath.Join(root, "db.tpl"), []byte("db"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(root, time.Now().Add(time.Minute), time.Now().Add(time.Minute))
+	added, err := c.get(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(added.partials) != 2 {
+		t.Errorf("number of partials was incorrect, got: '%v', want: '%v'\n", len(added.partials), 2)
+	}
+}
+
+// failingStore fails like an unreachable store
+type failingStore struct{}
+
+func (failingStore) GetSecret(spath string, ctx context.Context) (*store.Secret, error) {
+	return nil, errors.New("store unavailable")
+}
+
+func (failingStore) String() string {
+	return "failing"
+}
@@ -0,0 +1,261 @@
+package secretsfs
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+
+	sfsfh "github.com/muryoutaisuu/secretsfs/pkg/fusehelpers"
+	"github.com/muryoutaisuu/secretsfs/pkg/store"
+)
+
+// FIOTOTP shows the current RFC 6238 code of TOTP seeds stored in the store.
+// Following directory structure is implemented:
+//   	totp/
+//   	└── <path in store>
+//   	  	└── <key containing the seed>
+// A seed is either an otpauth:// URI or a base32 encoded secret. The
+// parameters of plain secrets are read from the keys digits, period and
+// algorithm of the same secret, or default to fio.totp.
+// The modification time of a file is the start of the current period, so
+// that tools watching files notice new codes.
+type FIOTOTP struct{}
+
+// totpParams contains everything needed to generate codes
+type totpParams struct {
+	secret    []byte
+	digits    int
+	period    int64
+	algorithm func() hash.Hash
+}
+
+// totpAlgorithms maps the algorithm names used by otpauth URIs to hashes
+var totpAlgorithms = map[string]func() hash.Hash{
+	"SHA1":   sha1.New,
+	"SHA256": sha256.New,
+	"SHA512": sha512.New,
+}
+
+var _ = (FIORoot)((*FIOTOTP)(nil))
+
+func (sf *FIOTOTP) Readdir(n *SfsNode, ctx context.Context) (out fs.DirStream, errno syscall.Errno) {
+	log.WithFields(log.Fields{"n": n, "n.npath": n.npath}).Debug("log values")
+
+	_, secpath := rootName(n.npath)
+	sec, err := (*store.GetStore()).GetSecret(secpath, ctx)
+	if err != nil {
+		log.WithFields(log.Fields{"secpath": secpath, "error": err, "calling": "GetSecret(secpath, ctx)"}).Error("Got error while getting secret")
+		return nil, syscall.ENOENT
+	}
+	if !sfsfh.IsDir(sec.Mode) {
+		return nil, syscall.ENOTDIR
+	}
+	var direntries []fuse.DirEntry
+	for _, v := range sec.Subs {
+		var mode uint32 = fuse.S_IFREG
+		if sfsfh.IsDir(v.Mode) {
+			mode = fuse.S_IFDIR
+		}
+		direntries = append(direntries, getDirEntry(n.npath, filepath.Base(v.Path), mode))
+	}
+	log.WithFields(log.Fields{"direntries": direntries}).Debug("log values")
+	return fs.NewListDirStream(direntries), fs.OK
+}
+
+func (sf *FIOTOTP) Lookup(n *SfsNode, ctx context.Context, name string, out *fuse.EntryOut) (node *fs.Inode, errno syscall.Errno) {
+	log.WithFields(log.Fields{
+		"n":          n,
+		"n.npath":    n.npath,
+		"name":       name,
+		"out.NodeId": out.NodeId}).Debug("log values")
+
+	prefixedfullname := filepath.Join(n.npath, name)
+	_, secpath := rootName(prefixedfullname)
+	sec, err := (*store.GetStore()).GetSecret(secpath, ctx)
+	if err != nil {
+		return nil, syscall.ENOENT
+	}
+	var mode uint32 = fuse.S_IFREG
+	if sfsfh.IsDir(sec.Mode) {
+		mode = fuse.S_IFDIR
+	}
+	return getLookupChild(n, prefixedfullname, mode, ctx, out)
+}
+
+func (sf *FIOTOTP) Open(n *SfsNode, ctx context.Context, flags uint32) (fh fs.FileHandle, fuseFlags uint32, errno syscall.Errno) {
+	log.WithFields(log.Fields{"n": n, "n.npath": n.npath}).Debug("log values")
+	content, errno := totpContent(n.npath, ctx)
+	if errno != fs.OK {
+		return nil, 0, errno
+	}
+	// codes change without changing the size, so they must not be cached, and
+	// all reads of a handle must belong to the same code
+	return &contentHandle{content: content}, fuse.FOPEN_DIRECT_IO, fs.OK
+}
+
+func (sf *FIOTOTP) Read(n *SfsNode, ctx context.Context, f fs.FileHandle, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
+	log.WithFields(log.Fields{"n": n, "n.npath": n.npath}).Debug("log values")
+	if h, ok := f.(*contentHandle); ok {
+		return readResultAt(h.content, dest, off), fs.OK
+	}
+	content, errno := totpContent(n.npath, ctx)
+	if errno != fs.OK {
+		return nil, errno
+	}
+	return readResultAt(content, dest, off), fs.OK
+}
+
+func (sf *FIOTOTP) Getattr(n *SfsNode, ctx context.Context, fh fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	log.WithFields(log.Fields{"n": n, "n.npath": n.npath}).Debug("log values")
+	out.Ino = GetInode(n.npath)
+	if IsRootPath(n.npath) || n.IsDir() {
+		return fs.OK
+	}
+	p, errno := getTOTPParams(n.npath, ctx)
+	if errno != fs.OK {
+		return errno
+	}
+	start := p.periodStart(time.Now())
+	out.Size = uint64(p.digits + 1)
+	out.Mtime = uint64(start.Unix())
+	out.Ctime = out.Mtime
+	return fs.OK
+}
+
+func (sf *FIOTOTP) FIOPath() string {
+	return "totp"
+}
+
+// totpContent returns the file content of the code currently valid at npath
+func totpContent(npath string, ctx context.Context) ([]byte, syscall.Errno) {
+	p, errno := getTOTPParams(npath, ctx)
+	if errno != fs.OK {
+		return nil, errno
+	}
+	return []byte(p.code(time.Now()) + "\n"), fs.OK
+}
+
+// getTOTPParams reads the seed at npath for the calling user
+func getTOTPParams(npath string, ctx context.Context) (*totpParams, syscall.Errno) {
+	_, secpath := rootName(npath)
+	sto := *store.GetStore()
+	sec, err := sto.GetSecret(secpath, ctx)
+	if err != nil {
+		log.WithFields(log.Fields{"secpath": secpath, "error": err}).Error("got error while getting secret")
+		return nil, syscall.ENOENT
+	}
+	if !sfsfh.IsFile(sec.Mode) {
+		return nil, syscall.EISDIR
+	}
+	var p *totpParams
+	seed := strings.TrimSpace(string(sec.Content))
+	if strings.HasPrefix(seed, "otpauth://") {
+		p, err = parseOTPAuthURI(seed)
+	} else {
+		// metadata is optional, so errors are ignored
+		data, _ := store.GetSecretData(sto, parentPath(secpath), ctx)
+		p, err = newTOTPParams(seed, metadataValue(data, "digits"), metadataValue(data, "period"), metadataValue(data, "algorithm"))
+	}
+	if err != nil {
+		log.WithFields(log.Fields{"secpath": secpath, "error": err}).Error("got error while parsing totp seed")
+		return nil, syscall.EIO
+	}
+	return p, fs.OK
+}
+
+// metadataValue returns the value of key in data, or the configured default
+func metadataValue(data map[string]interface{}, key string) string {
+	if v, ok := data[key]; ok {
+		return store.ValueToString(v)
+	}
+	return viper.GetString("fio.totp." + key)
+}
+
+// parseOTPAuthURI parses URIs like
+//  otpauth://totp/Example:alice?secret=JBSWY3DPEHPK3PXP&digits=8&period=60
+// Missing parameters default to fio.totp.
+func parseOTPAuthURI(uri string) (*totpParams, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	if u.Host != "totp" {
+		return nil, fmt.Errorf("msg=\"unsupported otpauth type\" type=\"%v\"\n", u.Host)
+	}
+	q := u.Query()
+	get := func(key string) string {
+		if v := q.Get(key); v != "" {
+			return v
+		}
+		return viper.GetString("fio.totp." + key)
+	}
+	return newTOTPParams(q.Get("secret"), get("digits"), get("period"), get("algorithm"))
+}
+
+// newTOTPParams parses the base32 encoded secret and parameters
+func newTOTPParams(secret, digits, period, algorithm string) (*totpParams, error) {
+	secret = strings.ToUpper(strings.Join(strings.Fields(secret), ""))
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.TrimRight(secret, "="))
+	if err != nil || len(key) == 0 {
+		return nil, fmt.Errorf("msg=\"invalid base32 secret\" error=\"%v\"\n", err)
+	}
+	p := &totpParams{secret: key}
+	if p.digits, err = strconv.Atoi(digits); err != nil || p.digits < 6 || p.digits > 10 {
+		return nil, fmt.Errorf("msg=\"invalid digits\" digits=\"%v\"\n", digits)
+	}
+	if p.period, err = strconv.ParseInt(period, 10, 64); err != nil || p.period <= 0 {
+		return nil, fmt.Errorf("msg=\"invalid period\" period=\"%v\"\n", period)
+	}
+	var ok bool
+	if p.algorithm, ok = totpAlgorithms[strings.ToUpper(algorithm)]; !ok {
+		return nil, fmt.Errorf("msg=\"unsupported algorithm\" algorithm=\"%v\"\n", algorithm)
+	}
+	return p, nil
+}
+
+// periodStart returns the start of the period containing t
+func (p *totpParams) periodStart(t time.Time) time.Time {
+	return time.Unix(t.Unix()/p.period*p.period, 0)
+}
+
+// code returns the code valid at t, see RFC 6238 and RFC 4226
+func (p *totpParams) code(t time.Time) string {
+	counter := make([]byte, 8)
+	binary.BigEndian.PutUint64(counter, uint64(t.Unix()/p.period))
+	mac := hmac.New(p.algorithm, p.secret)
+	mac.Write(counter)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := uint64(binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff)
+	mod := uint64(1)
+	for i := 0; i < p.digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", p.digits, value%mod)
+}
+
+func init() {
+	fioroot := FIOTOTP{}
+	fm := FIOMap{
+		Root: &fioroot,
+	}
+	RegisterRoot(&fm)
+}
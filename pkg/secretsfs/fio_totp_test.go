@@ -0,0 +1,64 @@
+package secretsfs
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+)
+
+func TestTOTPCode(t *testing.T) {
+	// test vectors of RFC 6238 appendix B
+	seeds := map[string]string{
+		"SHA1":   base32.StdEncoding.EncodeToString([]byte("12345678901234567890")),
+		"SHA256": base32.StdEncoding.EncodeToString([]byte("12345678901234567890123456789012")),
+		"SHA512": base32.StdEncoding.EncodeToString([]byte("1234567890123456789012345678901234567890123456789012345678901234")),
+	}
+	tables := []struct {
+		unix      int64
+		algorithm string
+		want      string
+	}{
+		{59, "SHA1", "94287082"},
+		{59, "SHA256", "46119246"},
+		{59, "SHA512", "90693936"},
+		{1111111109, "SHA1", "07081804"},
+		{1234567890, "SHA256", "91819424"},
+		{20000000000, "SHA512", "47863826"},
+	}
+
+	for _, table := range tables {
+		p, err := newTOTPParams(seeds[table.algorithm], "8", "30", table.algorithm)
+		if err != nil {
+			t.Errorf("got error for algorithm='%v': %v\n", table.algorithm, err)
+			continue
+		}
+		if got := p.code(time.Unix(table.unix, 0)); got != table.want {
+			t.Errorf("code at '%v' with '%v' was incorrect, got: '%v', want: '%v'\n", table.unix, table.algorithm, got, table.want)
+		}
+	}
+}
+
+func TestParseOTPAuthURI(t *testing.T) {
+	p, err := parseOTPAuthURI("otpauth://totp/Example:alice?secret=gezd%20gnbv&digits=6&period=60&algorithm=sha256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(p.secret) != "12345" || p.digits != 6 || p.period != 60 {
+		t.Errorf("parsed parameters were incorrect, got: '%v'\n", p)
+	}
+	if got, want := p.periodStart(time.Unix(119, 0)), time.Unix(60, 0); !got.Equal(want) {
+		t.Errorf("period start was incorrect, got: '%v', want: '%v'\n", got, want)
+	}
+
+	for _, uri := range []string{
+		"otpauth://hotp/Example?secret=GEZDGNBV&counter=1&digits=6&period=30&algorithm=SHA1",
+		"otpauth://totp/Example?secret=!!!&digits=6&period=30&algorithm=SHA1",
+		"otpauth://totp/Example?secret=GEZDGNBV&digits=4&period=30&algorithm=SHA1",
+		"otpauth://totp/Example?secret=GEZDGNBV&digits=6&period=0&algorithm=SHA1",
+		"otpauth://totp/Example?secret=GEZDGNBV&digits=6&period=30&algorithm=MD5",
+	} {
+		if _, err := parseOTPAuthURI(uri); err == nil {
+			t.Errorf("expected error for uri='%v'\n", uri)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package secretsfs
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+
+	"github.com/muryoutaisuu/secretsfs/pkg/store"
+)
+
+// FIOTransit encrypts and decrypts data with vault's transit secrets engine.
+// Following directory structure is implemented:
+//   	transit/
+//   	└── <key>
+//   	  	├── decrypt
+//   	  	└── encrypt
+// Data written into an opened file is processed with the vault token of the
+// calling user, as soon as it is read back from the same file handle.
+type FIOTransit struct{}
+
+// transitHandle is returned by Open and holds the written input and the
+// processed output of a single opened file
+type transitHandle struct {
+	mu     sync.Mutex
+	input  []byte
+	output []byte
+	// processed is true, if output belongs to the current input
+	processed bool
+}
+
+var _ = (FIORoot)((*FIOTransit)(nil))
+var _ = (FIOWriter)((*FIOTransit)(nil))
+var _ = (FIOSetattrer)((*FIOTransit)(nil))
+
+func (sf *FIOTransit) Readdir(n *SfsNode, ctx context.Context) (out fs.DirStream, errno syscall.Errno) {
+	log.WithFields(log.Fields{"n": n, "n.npath": n.npath}).Debug("log values")
+
+	var direntries []fuse.DirEntry
+	parts := subPathParts(n.npath)
+	switch len(parts) {
+	case 0:
+		keys, err := store.ListVault(viper.GetString("fio.transit.mount")+"/keys", ctx)
+		if err != nil {
+			// keys may still be accessed directly
+			log.WithFields(log.Fields{"n.npath": n.npath, "error": err}).Warn("got error while listing transit keys, probably not enough permissions")
+		}
+		for _, k := range keys {
+			direntries = append(direntries, getDirEntry(n.npath, k, fuse.S_IFDIR))
+		}
+	case 1:
+		direntries = append(direntries,
+			getDirEntry(n.npath, "decrypt", fuse.S_IFREG),
+			getDirEntry(n.npath, "encrypt", fuse.S_IFREG))
+	default:
+		return nil, syscall.ENOTDIR
+	}
+	log.WithFields(log.Fields{"direntries": direntries}).Debug("log values")
+	return fs.NewListDirStream(direntries), fs.OK
+}
+
+func (sf *FIOTransit) Lookup(n *SfsNode, ctx context.Context, name string, out *fuse.EntryOut) (node *fs.Inode, errno syscall.Errno) {
+	log.WithFields(log.Fields{
+		"n":          n,
+		"n.npath":    n.npath,
+		"name":       name,
+		"out.NodeId": out.NodeId}).Debug("log values")
+
+	prefixedfullname := filepath.Join(n.npath, name)
+	parts := subPathParts(prefixedfullname)
+	switch len(parts) {
+	case 1:
+		// keys are only checked on access, as they might not be listable
+		return getLookupChild(n, prefixedfullname, fuse.S_IFDIR, ctx, out)
+	case 2:
+		if parts[1] == "encrypt" || parts[1] == "decrypt" {
+			return getLookupChild(n, prefixedfullname, fuse.S_IFREG, ctx, out)
+		}
+	}
+	return nil, syscall.ENOENT
+}
+
+func (sf *FIOTransit) Open(n *SfsNode, ctx context.Context, flags uint32) (fh fs.FileHandle, fuseFlags uint32, errno syscall.Errno) {
+	log.WithFields(log.Fields{"n": n, "n.npath": n.npath}).Debug("log values")
+	if len(subPathParts(n.npath)) != 2 {
+		return nil, 0, syscall.EISDIR
+	}
+	return &transitHandle{}, fuse.FOPEN_DIRECT_IO, fs.OK
+}
+
+func (sf *FIOTransit) Read(n *SfsNode, ctx context.Context, f fs.FileHandle, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
+	log.WithFields(log.Fields{"n": n, "n.npath": n.npath}).Debug("log values")
+	h, ok := f.(*transitHandle)
+	if !ok {
+		return nil, syscall.EBADF
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.processed {
+		parts := subPathParts(n.npath)
+		output, err := transit(ctx, parts[1], parts[0], h.input)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"n.npath": n.npath,
+				"error":   err}).Error("got error while processing data with transit")
+			return nil, syscall.EIO
+		}
+		h.output = output
+		h.processed = true
+	}
+	if off >= int64(len(h.output)) {
+		return fuse.ReadResultData(nil), fs.OK
+	}
+	end := off + int64(len(dest))
+	if end > int64(len(h.output)) {
+		end = int64(len(h.output))
+	}
+	return fuse.ReadResultData(h.output[off:end]), fs.OK
+}
+
+func (sf *FIOTransit) Write(n *SfsNode, ctx context.Context, f fs.FileHandle, data []byte, off int64) (written uint32, errno syscall.Errno) {
+	log.WithFields(log.Fields{"n": n, "n.npath": n.npath, "off": off}).Debug("log values")
+	h, ok := f.(*transitHandle)
+	if !ok {
+		return 0, syscall.EBADF
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if end := off + int64(len(data)); end > int64(len(h.input)) {
+		h.input = append(h.input, make([]byte, end-int64(len(h.input)))...)
+	}
+	copy(h.input[off:], data)
+	h.processed = false
+	return uint32(len(data)), fs.OK
+}
+
+func (sf *FIOTransit) Setattr(n *SfsNode, ctx context.Context, f fs.FileHandle, in *fuse.SetAttrIn, out *fuse.AttrOut) syscall.Errno {
+	log.WithFields(log.Fields{"n": n, "n.npath": n.npath}).Debug("log values")
+	if h, ok := f.(*transitHandle); ok {
+		if size, ok := in.GetSize(); ok {
+			h.mu.Lock()
+			if size < uint64(len(h.input)) {
+				h.input = h.input[:size]
+			}
+			h.processed = false
+			h.mu.Unlock()
+		}
+	}
+	return sf.Getattr(n, ctx, f, out)
+}
+
+func (sf *FIOTransit) Getattr(n *SfsNode, ctx context.Context, fh fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	log.WithFields(log.Fields{"n": n, "n.npath": n.npath}).Debug("log values")
+	if h, ok := fh.(*transitHandle); ok {
+		h.mu.Lock()
+		if h.processed {
+			out.Size = uint64(len(h.output))
+		} else {
+			out.Size = uint64(len(h.input))
+		}
+		h.mu.Unlock()
+	}
+	out.Ino = GetInode(n.npath)
+	return fs.OK
+}
+
+func (sf *FIOTransit) FIOPath() string {
+	return "transit"
+}
+
+// transit encrypts or decrypts input with key, depending on operation
+func transit(ctx context.Context, operation, key string, input []byte) ([]byte, error) {
+	spath := viper.GetString("fio.transit.mount") + "/" + operation + "/" + key
+	switch operation {
+	case "encrypt":
+		data := map[string]interface{}{
+			"plaintext": base64.StdEncoding.EncodeToString(input),
+		}
+		resp, err := store.WriteVault(spath, data, ctx)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(store.ValueToString(resp["ciphertext"]) + "\n"), nil
+	case "decrypt":
+		data := map[string]interface{}{
+			"ciphertext": strings.TrimSpace(string(input)),
+		}
+		resp, err := store.WriteVault(spath, data, ctx)
+		if err != nil {
+			return nil, err
+		}
+		return base64.StdEncoding.DecodeString(store.ValueToString(resp["plaintext"]))
+	}
+	return nil, fmt.Errorf("msg=\"unknown transit operation\" operation=\"%v\"\n", operation)
+}
+
+func init() {
+	fioroot := FIOTransit{}
+	fm := FIOMap{
+		Root: &fioroot,
+	}
+	RegisterRoot(&fm)
+}
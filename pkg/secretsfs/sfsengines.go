@@ -0,0 +1,143 @@
+package secretsfs
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/spf13/viper"
+)
+
+// templateEngine renders body, the content of the templatefile at tpath
+// without front matter. lines is the number of lines of the stripped front
+// matter, engines add it to the line numbers of their errors. root is the
+// templatespath of tpath, or empty.
+type templateEngine func(root, tpath string, body []byte, lines int, thesecret secret) ([]byte, error)
+
+// templateEngines maps the names of template engines to their
+// implementation
+var templateEngines = map[string]templateEngine{
+	"go":       executeGoTemplate,
+	"consul":   executeConsulTemplate,
+	"envsubst": executeEnvsubst,
+}
+
+// selectTemplateEngine returns the name of the engine rendering tpath. The
+// engine declared in the front matter wins over the engines configured for
+// extensions in fio.templatefiles.engines, which win over go templates.
+func selectTemplateEngine(tpath string, fm *frontMatter) string {
+	if fm != nil && fm.Engine != "" {
+		return fm.Engine
+	}
+	ext := strings.TrimPrefix(filepath.Ext(tpath), ".")
+	if name, ok := viper.GetStringMapString("fio.templatefiles.engines")[strings.ToLower(ext)]; ok && ext != "" {
+		return name
+	}
+	return "go"
+}
+
+// consulSecret is returned by the secret function of consul-template
+type consulSecret struct {
+	// Data contains the keys of the secret. For templates written for KV
+	// version 2, the keys are available below data too.
+	Data map[string]interface{}
+}
+
+// executeConsulTemplate executes body like consul-template does, e.g.
+//  {{ with secret "secret/app/db" }}{{ .Data.password }}{{ end }}
+// The prefixes in fio.templatefiles.consulprefixes are removed from the
+// paths, so that paths of vault's API map to paths in the store.
+func executeConsulTemplate(root, tpath string, body []byte, lines int, thesecret secret) ([]byte, error) {
+	storePath := func(p string) string {
+		for _, prefix := range viper.GetStringSlice("fio.templatefiles.consulprefixes") {
+			if strings.HasPrefix(p, prefix) {
+				return strings.TrimPrefix(p, prefix)
+			}
+		}
+		return p
+	}
+	funcs := template.FuncMap{
+		"secret": func(p string, args ...string) (*consulSecret, error) {
+			if len(args) > 0 {
+				return nil, fmt.Errorf("msg=\"writing secrets is not supported\" secret=\"%v\"\n", p)
+			}
+			m, err := thesecret.GetMap(storePath(p))
+			if err != nil {
+				return nil, err
+			}
+			data := make(map[string]interface{}, len(m)+1)
+			for k, v := range m {
+				data[k] = v
+			}
+			if _, ok := data["data"]; !ok {
+				data["data"] = m
+			}
+			return &consulSecret{Data: data}, nil
+		},
+		"secrets": func(p string) ([]string, error) {
+			return thesecret.List(storePath(p))
+		},
+		"base64Decode": b64dec,
+		"base64Encode": b64enc,
+		"env":          templateEnv,
+		"indent":       indent,
+		"join":         func(sep string, list []string) string { return strings.Join(list, sep) },
+		"replaceAll":   func(old, new, s string) string { return strings.Replace(s, old, new, -1) },
+		"split":        func(sep, s string) []string { return strings.Split(s, sep) },
+		"toJSON":       toJSON,
+		"toJSONPretty": toPrettyJSON,
+		"toLower":      strings.ToLower,
+		"toUpper":      strings.ToUpper,
+		"toYAML":       toYAML,
+		"trimSpace":    strings.TrimSpace,
+	}
+	parser, err := template.New(filepath.Base(tpath)).Funcs(funcs).Parse(string(padTemplate(body, lines)))
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := parser.Execute(&buf, nil); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// envsubstReference matches ${path:key} and ${VAR}
+var envsubstReference = regexp.MustCompile(`\$\{([^}:]+)(?::([^}]+))?\}`)
+
+// executeEnvsubst replaces ${path:key} with the key of the secret at path,
+// and ${VAR} with the environment variable of the secretsfs process, which
+// is empty if unset. Only the environment variables in
+// fio.templatefiles.allowedenv may be referenced, see templateEnv.
+func executeEnvsubst(root, tpath string, body []byte, lines int, thesecret secret) ([]byte, error) {
+	var err error
+	content := envsubstReference.ReplaceAllFunc(body, func(ref []byte) []byte {
+		if err != nil {
+			return nil
+		}
+		m := envsubstReference.FindSubmatch(ref)
+		function := "Get"
+		var v string
+		var gerr error
+		if len(m[2]) == 0 {
+			function = "env"
+			v, gerr = templateEnv(string(m[1]))
+		} else {
+			v, gerr = thesecret.Get(path.Join(string(m[1]), string(m[2])))
+		}
+		if gerr != nil {
+			line := lines + bytes.Count(body[:bytes.Index(body, ref)], []byte("\n")) + 1
+			// same format as errors of text/template, see redactRenderError
+			err = fmt.Errorf("template: %s:%d: executing %q at <%s>: error calling %s: %v", filepath.Base(tpath), line, filepath.Base(tpath), ref, function, gerr)
+		}
+		return []byte(v)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return content, nil
+}
@@ -0,0 +1,125 @@
+package secretsfs
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+
+	sfsfh "github.com/muryoutaisuu/secretsfs/pkg/fusehelpers"
+	"github.com/muryoutaisuu/secretsfs/pkg/store"
+)
+
+func TestTemplateEngines(t *testing.T) {
+	sto := store.Map{
+		"apps/db": {Path: "apps/db", Mode: sfsfh.DIRREAD, Subs: []*store.Secret{
+			{Path: "apps/db/user", Mode: sfsfh.FILEREAD},
+			{Path: "apps/db/password", Mode: sfsfh.FILEREAD},
+		}},
+		"apps/db/user":     {Path: "apps/db/user", Mode: sfsfh.FILEREAD, Content: []byte("admin")},
+		"apps/db/password": {Path: "apps/db/password", Mode: sfsfh.FILEREAD, Content: []byte("secret")},
+	}
+	active := store.GetStore()
+	previous := *active
+	*active = sto
+	defer func() { *active = previous }()
+	os.Setenv("SECRETSFS_TEST_ENV", "env")
+	os.Setenv("SECRETSFS_TEST_TOKEN", "token")
+	defer os.Unsetenv("SECRETSFS_TEST_ENV")
+	defer os.Unsetenv("SECRETSFS_TEST_TOKEN")
+	viper.Set("fio.templatefiles.allowedenv", []string{"SECRETSFS_TEST_ENV"})
+	defer viper.Set("fio.templatefiles.allowedenv", []string{})
+
+	ctx := context.Background()
+	tables := []struct {
+		engine templateEngine
+		body   string
+		want   string
+	}{
+		{executeConsulTemplate, `{{ with secret "apps/db" }}{{ .Data.user }}:{{ .Data.password }}{{ end }}`, "admin:secret"},
+		{executeConsulTemplate, `{{ with secret "apps/db" }}{{ .Data.data.password | base64Encode }}{{ end }}`, "c2VjcmV0"},
+		{executeConsulTemplate, `{{ range secrets "apps/db" }}{{ . }};{{ end }}`, "password;user;"},
+		{executeConsulTemplate, `{{ env "SECRETSFS_TEST_ENV" }}`, "env"},
+		{executeEnvsubst, "user=${apps/db:user}\npassword=${apps/db:password}\nenv=${SECRETSFS_TEST_ENV}\n", "user=admin\npassword=secret\nenv=env\n"},
+	}
+	for _, table := range tables {
+		got, err := table.engine("", "test.conf", []byte(table.body), 0, secret{ctx: &ctx})
+		if err != nil {
+			t.Errorf("got error for '%v': %v\n", table.body, err)
+			continue
+		}
+		if string(got) != table.want {
+			t.Errorf("rendered '%v' was incorrect, got: '%v', want: '%v'\n", table.body, string(got), table.want)
+		}
+	}
+
+	_, err := executeEnvsubst("", "test.conf", []byte("a=1\nb=${apps/db:missing}\n"), 3, secret{ctx: &ctx})
+	if err == nil || !strings.Contains(err.Error(), "test.conf:5:") {
+		t.Errorf("error of missing secret was incorrect, got: '%v'\n", err)
+	}
+
+	// environment variables not allowed may not be read by any engine
+	if got, err := executeEnvsubst("", "test.conf", []byte("token=${SECRETSFS_TEST_TOKEN}\n"), 0, secret{ctx: &ctx}); err == nil {
+		t.Errorf("envsubst read environment variable not allowed, got: '%v'\n", string(got))
+	}
+	if got, err := executeConsulTemplate("", "test.conf", []byte(`{{ env "SECRETSFS_TEST_TOKEN" }}`), 0, secret{ctx: &ctx}); err == nil {
+		t.Errorf("consul template read environment variable not allowed, got: '%v'\n", string(got))
+	}
+
+	engines := []struct {
+		tpath string
+		fm    *frontMatter
+		want  string
+	}{
+		{"app.conf", &frontMatter{}, "go"},
+		{"app.conf", &frontMatter{Engine: "envsubst"}, "envsubst"},
+	}
+	for _, table := range engines {
+		if got := selectTemplateEngine(table.tpath, table.fm); got != table.want {
+			t.Errorf("engine of '%v' was incorrect, got: '%v', want: '%v'\n", table.tpath, got, table.want)
+		}
+	}
+
+	// the front matter is stripped by every engine in its own syntax
+	dir, err := ioutil.TempDir("", "templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"db.json", "---\n{\"engine\": \"envsubst\", \"format\": \"json\"}\n---\n{\"user\": \"${apps/db:user}\"}\n", "{\"user\": \"admin\"}\n"},
+		{"db.ctmpl", "---\n{\"engine\": \"consul\"}\n---\n{{ with secret \"apps/db\" }}{{ .Data.user }}{{ end }}\n", "admin\n"},
+		{"db.conf", "---\n{\"format\": \"json\"}\n---\n{\"user\": \"{{ .Get \"apps/db/user\" }}\"}\n", "{\"user\": \"admin\"}\n"},
+	}
+	for _, file := range files {
+		tpath := filepath.Join(dir, file.name)
+		if err := ioutil.WriteFile(tpath, []byte(file.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		got, err := executeTemplatefile("", tpath, secret{ctx: &ctx})
+		if err != nil {
+			t.Errorf("got error for '%v': %v\n", file.name, err)
+			continue
+		}
+		if string(got) != file.want {
+			t.Errorf("rendered '%v' was incorrect, got: '%v', want: '%v'\n", file.name, string(got), file.want)
+		}
+	}
+
+	// line numbers of errors include the front matter
+	tpath := filepath.Join(dir, "missing.conf")
+	if err := ioutil.WriteFile(tpath, []byte("---\n{\"engine\": \"envsubst\"}\n---\nb=${apps/db:missing}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := executeTemplatefile("", tpath, secret{ctx: &ctx}); err == nil || !strings.Contains(err.Error(), "missing.conf:4:") {
+		t.Errorf("error of missing secret was incorrect, got: '%v'\n", err)
+	}
+}
@@ -0,0 +1,148 @@
+package secretsfs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+
+	"github.com/muryoutaisuu/secretsfs/pkg/store"
+)
+
+// secretFormats maps formats to functions, which render all keys of a secret
+// at once
+var secretFormats = map[string]func(data map[string]interface{}) ([]byte, error){
+	"json":       renderJSON,
+	"yaml":       renderYAML,
+	"env":        renderEnv,
+	"properties": renderProperties,
+}
+
+// getSecretFormat returns the format of name, if name is a file rendering a
+// secret as a whole, e.g. ".json".
+// Only formats enabled in fio.secretsfiles.formats are considered.
+func getSecretFormat(name string) (string, bool) {
+	if !strings.HasPrefix(name, ".") {
+		return "", false
+	}
+	for _, f := range viper.GetStringSlice("fio.secretsfiles.formats") {
+		if _, ok := secretFormats[f]; ok && name == "."+f {
+			return f, true
+		}
+	}
+	return "", false
+}
+
+// renderSecretFormat renders data in format
+func renderSecretFormat(data map[string]interface{}, format string) ([]byte, error) {
+	render, ok := secretFormats[format]
+	if !ok {
+		return nil, fmt.Errorf("msg=\"unknown format\" format=\"%v\"\n", format)
+	}
+	return render(data)
+}
+
+func renderJSON(data map[string]interface{}) ([]byte, error) {
+	content, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(content, '\n'), nil
+}
+
+func renderYAML(data map[string]interface{}) ([]byte, error) {
+	return yaml.Marshal(data)
+}
+
+// renderEnv renders data as dotenv file. Keys are converted to valid variable
+// names, values are double quoted.
+func renderEnv(data map[string]interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	for _, k := range sortedKeys(data) {
+		fmt.Fprintf(&buf, "%s=\"%s\"\n", envName(k), envEscaper.Replace(store.ValueToString(data[k])))
+	}
+	return buf.Bytes(), nil
+}
+
+// envEscaper escapes all characters with a special meaning inside of double
+// quoted dotenv values
+var envEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	`"`, `\"`,
+	"$", `\$`,
+	"`", "\\`",
+	"\n", `\n`,
+	"\r", `\r`,
+)
+
+// envName replaces all characters not allowed in variable names with '_'
+func envName(k string) string {
+	name := []rune(k)
+	for i, r := range name {
+		if !(r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+			name[i] = '_'
+		}
+	}
+	if len(name) == 0 || name[0] >= '0' && name[0] <= '9' {
+		return "_" + string(name)
+	}
+	return string(name)
+}
+
+// renderProperties renders data as java properties file
+func renderProperties(data map[string]interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	for _, k := range sortedKeys(data) {
+		fmt.Fprintf(&buf, "%s=%s\n", escapeProperty(k, true), escapeProperty(store.ValueToString(data[k]), false))
+	}
+	return buf.Bytes(), nil
+}
+
+// escapeProperty escapes s like java.util.Properties.store does. Spaces are
+// escaped everywhere in keys, but only as leading character in values.
+func escapeProperty(s string, isKey bool) string {
+	var buf strings.Builder
+	for i, r := range s {
+		switch r {
+		case '\\':
+			buf.WriteString(`\\`)
+		case '\t':
+			buf.WriteString(`\t`)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\r':
+			buf.WriteString(`\r`)
+		case '\f':
+			buf.WriteString(`\f`)
+		case '=', ':', '#', '!':
+			buf.WriteRune('\\')
+			buf.WriteRune(r)
+		case ' ':
+			if isKey || i == 0 {
+				buf.WriteRune('\\')
+			}
+			buf.WriteRune(r)
+		default:
+			if r < 0x20 || r > 0x7e {
+				for _, u := range utf16.Encode([]rune{r}) {
+					fmt.Fprintf(&buf, `\u%04X`, u)
+				}
+				continue
+			}
+			buf.WriteRune(r)
+		}
+	}
+	return buf.String()
+}
+
+// sortedKeys returns the keys of m in sorted order
+func sortedKeys(m map[string]interface{}) []string {
+	keys := GetMapStringKeys(m)
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,76 @@
+package secretsfs
+
+import (
+	"testing"
+)
+
+func TestRenderSecretFormat(t *testing.T) {
+	data := map[string]interface{}{
+		"password":  "p\"a$s`s\\\nword",
+		"db.user":   "admin",
+		"1st key":   " lead: ü",
+		"port":      float64(5432),
+		"structure": map[string]interface{}{"tls": true},
+	}
+	tables := []struct {
+		format string
+		want   string
+	}{
+		{"env", "_1st_key=\" lead: ü\"\n" +
+			"db_user=\"admin\"\n" +
+			"password=\"p\\\"a\\$s\\`s\\\\\\nword\"\n" +
+			"port=\"5432\"\n" +
+			"structure=\"{\\\"tls\\\":true}\"\n"},
+		{"properties", "1st\\ key=\\ lead\\: \\u00FC\n" +
+			"db.user=admin\n" +
+			"password=p\"a$s`s\\\\\\nword\n" +
+			"port=5432\n" +
+			"structure={\"tls\"\\:true}\n"},
+		{"json", "{\n" +
+			"  \"1st key\": \" lead: ü\",\n" +
+			"  \"db.user\": \"admin\",\n" +
+			"  \"password\": \"p\\\"a$s`s\\\\\\nword\",\n" +
+			"  \"port\": 5432,\n" +
+			"  \"structure\": {\n" +
+			"    \"tls\": true\n" +
+			"  }\n" +
+			"}\n"},
+	}
+
+	for _, table := range tables {
+		got, err := renderSecretFormat(data, table.format)
+		if err != nil {
+			t.Errorf("got error for format='%v': %v\n", table.format, err)
+			continue
+		}
+		if string(got) != table.want {
+			t.Errorf("rendering of format '%v' was incorrect, got: '%v', want: '%v'\n", table.format, string(got), table.want)
+		}
+	}
+
+	if _, err := renderSecretFormat(data, "xml"); err == nil {
+		t.Errorf("expected error for unknown format\n")
+	}
+}
+
+func TestEscapeProperty(t *testing.T) {
+	tables := []struct {
+		s     string
+		isKey bool
+		want  string
+	}{
+		{"a b", true, "a\\ b"},
+		{"a b", false, "a b"},
+		{" a", false, "\\ a"},
+		{"k=v#!", false, "k\\=v\\#\\!"},
+		{"\t\r\f", false, "\\t\\r\\f"},
+		{"😀", false, "\\uD83D\\uDE00"},
+	}
+
+	for _, table := range tables {
+		got := escapeProperty(table.s, table.isKey)
+		if got != table.want {
+			t.Errorf("escaping of '%v' was incorrect, got: '%v', want: '%v'\n", table.s, got, table.want)
+		}
+	}
+}
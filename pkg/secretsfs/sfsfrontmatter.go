@@ -0,0 +1,182 @@
+package secretsfs
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// frontMatterDelimiter starts and ends the front matter of a templatefile
+const frontMatterDelimiter = "---"
+
+// frontMatter contains the optional settings at the beginning of a
+// templatefile, e.g.
+//  ---
+//  mode: "0400"
+//  name: app.json
+//  groups: [appl]
+//  contenttype: application/json
+//  engine: go
+//  format: json
+//  ---
+// The front matter is stripped before rendering.
+type frontMatter struct {
+	// Mode contains the octal permissions of the rendered file
+	Mode string `yaml:"mode"`
+	// Name is shown instead of the name of the templatefile
+	Name string `yaml:"name"`
+	// Groups restricts the rendered file to members of at least one group
+	Groups []string `yaml:"groups"`
+	// ContentType is shown as extended attribute user.mime_type
+	ContentType string `yaml:"contenttype"`
+	// Engine is the name of the template engine rendering the templatefile
+	Engine string `yaml:"engine"`
+	// Format is validated on the rendered output, see outputValidators
+	Format string `yaml:"format"`
+}
+
+// maxFrontMatterSize limits the bytes read for the front matter of a
+// templatefile, when only its front matter is needed
+const maxFrontMatterSize = 64 * 1024
+
+// parseFrontMatter splits content into its front matter and the template
+// body following it. lines is the number of lines of the front matter, which
+// engines add to line numbers, so that they still match the templatefile.
+func parseFrontMatter(content []byte) (fm *frontMatter, body []byte, lines int, err error) {
+	fm = &frontMatter{}
+	header, offset, ok := splitFrontMatter(content)
+	if !ok {
+		return fm, content, 0, nil
+	}
+	if offset < 0 {
+		return nil, nil, 0, fmt.Errorf("msg=\"front matter is not terminated\" delimiter=\"%v\"\n", frontMatterDelimiter)
+	}
+	if err := yaml.Unmarshal(header, fm); err != nil {
+		return nil, nil, 0, fmt.Errorf("msg=\"invalid front matter\" error=\"%v\"\n", err)
+	}
+	if strings.ContainsAny(fm.Name, "/\x00") || fm.Name == "." || fm.Name == ".." {
+		return nil, nil, 0, fmt.Errorf("msg=\"invalid name in front matter\" name=\"%v\"\n", fm.Name)
+	}
+	if _, err := fm.perm(); err != nil {
+		return nil, nil, 0, err
+	}
+	return fm, content[offset:], bytes.Count(content[:offset], []byte("\n")), nil
+}
+
+// padTemplate prepends a go template comment spanning lines lines to body, so
+// that line numbers of errors of go and consul templates match the
+// templatefile including its front matter
+func padTemplate(body []byte, lines int) []byte {
+	if lines == 0 {
+		return body
+	}
+	return append([]byte("{{/*"+strings.Repeat("\n", lines)+"*/}}"), body...)
+}
+
+// splitFrontMatter returns the front matter at the beginning of content and
+// the offset of the template following it. ok is false, if content doesn't
+// start with a front matter, offset is -1 if the front matter isn't
+// terminated. Lines may end with \n or \r\n.
+func splitFrontMatter(content []byte) (header []byte, offset int, ok bool) {
+	line, next := nextLine(content, 0)
+	if line != frontMatterDelimiter {
+		return nil, 0, false
+	}
+	start := next
+	for next < len(content) {
+		pos := next
+		line, next = nextLine(content, pos)
+		if line == frontMatterDelimiter {
+			return content[start:pos], next, true
+		}
+	}
+	return nil, -1, true
+}
+
+// nextLine returns the line of content starting at pos without its line
+// ending, and the position of the following line
+func nextLine(content []byte, pos int) (string, int) {
+	end := bytes.IndexByte(content[pos:], '\n')
+	if end < 0 {
+		return strings.TrimSuffix(string(content[pos:]), "\r"), len(content)
+	}
+	return strings.TrimSuffix(string(content[pos:pos+end]), "\r"), pos + end + 1
+}
+
+// readFrontMatter returns the front matter of the templatefile at tpath. Only
+// the front matter is read, not the whole templatefile.
+func readFrontMatter(tpath string) (*frontMatter, error) {
+	f, err := os.Open(tpath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(io.LimitReader(f, maxFrontMatterSize))
+	var content []byte
+	for {
+		line, err := r.ReadBytes('\n')
+		content = append(content, line...)
+		trimmed := strings.TrimSuffix(strings.TrimSuffix(string(line), "\n"), "\r")
+		// stop after the first line, if it doesn't start a front matter, or
+		// after the delimiter terminating the front matter
+		if trimmed != frontMatterDelimiter && len(content) == len(line) {
+			return &frontMatter{}, nil
+		}
+		if trimmed == frontMatterDelimiter && len(content) > len(line) {
+			break
+		}
+		if err == io.EOF {
+			if len(content) == maxFrontMatterSize {
+				return nil, fmt.Errorf("msg=\"front matter exceeds the maximum size\" size=\"%v\"\n", maxFrontMatterSize)
+			}
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	fm, _, _, err := parseFrontMatter(content)
+	return fm, err
+}
+
+// displayName returns the name under which the templatefile filename is
+// shown
+func (fm *frontMatter) displayName(filename string) string {
+	if fm.Name != "" {
+		return fm.Name
+	}
+	return filename
+}
+
+// perm returns the declared permissions, or 0 if none are declared
+func (fm *frontMatter) perm() (uint32, error) {
+	if fm.Mode == "" {
+		return 0, nil
+	}
+	mode, err := strconv.ParseUint(fm.Mode, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("msg=\"invalid mode in front matter\" mode=\"%v\"\n", fm.Mode)
+	}
+	return uint32(mode), nil
+}
+
+// permits checks whether pu is member of one of the declared groups
+func (fm *frontMatter) permits(pu *pathUser) bool {
+	if len(fm.Groups) == 0 {
+		return true
+	}
+	for _, g := range fm.Groups {
+		for _, ug := range pu.Groups {
+			if g == ug {
+				return true
+			}
+		}
+	}
+	return false
+}
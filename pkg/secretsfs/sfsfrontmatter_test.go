@@ -0,0 +1,131 @@
+package secretsfs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseFrontMatter(t *testing.T) {
+	tables := []struct {
+		content string
+		fm      frontMatter
+		body    string
+		err     bool
+	}{
+		{"plain {{ .Get \"a\" }}\n", frontMatter{}, "plain {{ .Get \"a\" }}\n", false},
+		{"---\n{\"mode\": \"0400\", \"name\": \"app.json\", \"groups\": [\"appl\"], \"contenttype\": \"application/json\"}\n---\n{}\n",
+			frontMatter{Mode: "0400", Name: "app.json", Groups: []string{"appl"}, ContentType: "application/json"}, "{}\n", false},
+		{"---\n{\"name\": \"x\"}\n---", frontMatter{Name: "x"}, "", false},
+		{"---\n{\"name\": \"x\"}\n", frontMatter{}, "", true},
+		{"---\n{\"name\": \"../x\"}\n---\n", frontMatter{}, "", true},
+		{"---\n{\"mode\": \"999\"}\n---\n", frontMatter{}, "", true},
+		// CRLF line endings, e.g. of templates edited on windows
+		{"---\r\n{\"groups\": [\"appl\"]}\r\n---\r\n{}\r\n", frontMatter{Groups: []string{"appl"}}, "{}\r\n", false},
+		{"---\r\n{\"name\": \"x\"}\r\n", frontMatter{}, "", true},
+	}
+	for _, table := range tables {
+		fm, body, lines, err := parseFrontMatter([]byte(table.content))
+		if (err != nil) != table.err {
+			t.Errorf("error for '%v' was incorrect, got: '%v', want error: '%v'\n", table.content, err, table.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if !reflect.DeepEqual(*fm, table.fm) {
+			t.Errorf("front matter of '%v' was incorrect, got: '%v', want: '%v'\n", table.content, *fm, table.fm)
+		}
+		if string(body) != table.body {
+			t.Errorf("body of '%v' was incorrect, got: '%v', want: '%v'\n", table.content, string(body), table.body)
+		}
+		// line numbers must still match the templatefile
+		padded := string(padTemplate(body, lines))
+		if strings.Count(padded, "\n") != strings.Count(table.content, "\n") {
+			t.Errorf("number of lines of '%v' changed, got: '%v'\n", table.content, padded)
+		}
+	}
+
+	perm, _ := (&frontMatter{Mode: "0400"}).perm()
+	if perm != 0400 {
+		t.Errorf("perm was incorrect, got: '%o', want: '%o'\n", perm, 0400)
+	}
+	fm := &frontMatter{Groups: []string{"appl", "ops"}}
+	if !fm.permits(&pathUser{Groups: []string{"users", "ops"}}) || fm.permits(&pathUser{Groups: []string{"users"}}) {
+		t.Errorf("permits was incorrect for groups '%v'\n", fm.Groups)
+	}
+}
+
+func TestReadFrontMatter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// the body isn't read, so it may be larger than maxFrontMatterSize
+	body := strings.Repeat("x", 2*maxFrontMatterSize)
+	tables := []struct {
+		content string
+		fm      frontMatter
+		err     bool
+	}{
+		{body, frontMatter{}, false},
+		{"---\n{\"name\": \"app.json\"}\n---\n" + body, frontMatter{Name: "app.json"}, false},
+		{"---\r\n{\"groups\": [\"appl\"]}\r\n---\r\n" + body, frontMatter{Groups: []string{"appl"}}, false},
+		{"---\n{\"name\": \"app.json\"}\n---", frontMatter{Name: "app.json"}, false},
+		{"---\n" + body, frontMatter{}, true},
+	}
+	tpath := filepath.Join(dir, "app.json.tmpl")
+	for _, table := range tables {
+		if err := ioutil.WriteFile(tpath, []byte(table.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		fm, err := readFrontMatter(tpath)
+		if (err != nil) != table.err {
+			t.Errorf("error for '%.20v' was incorrect, got: '%v', want error: '%v'\n", table.content, err, table.err)
+			continue
+		}
+		if err == nil && !reflect.DeepEqual(*fm, table.fm) {
+			t.Errorf("front matter of '%.20v' was incorrect, got: '%v', want: '%v'\n", table.content, *fm, table.fm)
+		}
+	}
+}
+
+func TestGetTemplateEntries(t *testing.T) {
+	root, err := ioutil.TempDir("", "templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	files := map[string]string{
+		"app.json.tmpl": "---\n{\"name\": \"app.json\"}\n---\n{}\n",
+		"app.json":      "hidden by the declared name",
+		"plain.conf":    "plain",
+		"header.tpl":    "partial",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(root, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := getTemplateEntries(root, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"app.json":   "app.json.tmpl",
+		"plain.conf": "plain.conf",
+	}
+	if len(entries) != len(want) {
+		t.Errorf("number of entries was incorrect, got: '%v', want: '%v'\n", len(entries), len(want))
+	}
+	for name, file := range want {
+		if e, ok := entries[name]; !ok || e.unixpath != filepath.Join(root, file) {
+			t.Errorf("entry '%v' was incorrect, got: '%v', want: '%v'\n", name, e, file)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package secretsfs
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"text/template"
+
+	"github.com/spf13/viper"
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v3"
+)
+
+// templateFuncs are the functions available in templatefiles. Functions
+// taking several arguments expect the value last, so that they can be used in
+// pipelines, e.g.
+//  {{ .Get "app/db/password" | b64enc | quote }}
+var templateFuncs = template.FuncMap{
+	// encoding
+	"b64enc":       b64enc,
+	"b64dec":       b64dec,
+	"toJson":       toJSON,
+	"toPrettyJson": toPrettyJSON,
+	"fromJson":     fromJSON,
+	"toYaml":       toYAML,
+	"fromYaml":     fromYAML,
+
+	// defaults
+	"default":  defaultValue,
+	"required": required,
+	"empty":    isEmpty,
+
+	// strings
+	"indent":     indent,
+	"nindent":    nindent,
+	"quote":      quote,
+	"squote":     squote,
+	"upper":      strings.ToUpper,
+	"lower":      strings.ToLower,
+	"trim":       strings.TrimSpace,
+	"trimPrefix": func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+	"trimSuffix": func(suffix, s string) string { return strings.TrimSuffix(s, suffix) },
+	"replace":    func(old, new, s string) string { return strings.Replace(s, old, new, -1) },
+	"contains":   func(substr, s string) bool { return strings.Contains(s, substr) },
+	"hasPrefix":  func(prefix, s string) bool { return strings.HasPrefix(s, prefix) },
+	"hasSuffix":  func(suffix, s string) bool { return strings.HasSuffix(s, suffix) },
+	"split":      func(sep, s string) []string { return strings.Split(s, sep) },
+	"join":       func(sep string, list []string) string { return strings.Join(list, sep) },
+	"repeat":     func(count int, s string) string { return strings.Repeat(s, count) },
+
+	// hashing
+	"sha256sum": sha256sum,
+	"bcrypt":    bcryptHash,
+	"htpasswd":  htpasswd,
+
+	// environment of the secretsfs process, not of the calling user
+	"env": templateEnv,
+}
+
+func b64enc(s string) string {
+	return base64.StdEncoding.EncodeToString([]byte(s))
+}
+
+func b64dec(s string) (string, error) {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func toJSON(v interface{}) (string, error) {
+	b, err := json.Marshal(v)
+	return string(b), err
+}
+
+func toPrettyJSON(v interface{}) (string, error) {
+	b, err := json.MarshalIndent(v, "", "  ")
+	return string(b), err
+}
+
+func fromJSON(s string) (interface{}, error) {
+	var v interface{}
+	err := json.Unmarshal([]byte(s), &v)
+	return v, err
+}
+
+// toYAML returns the YAML representation of v without trailing newline, so
+// that it can be indented
+func toYAML(v interface{}) (string, error) {
+	b, err := yaml.Marshal(v)
+	return strings.TrimSuffix(string(b), "\n"), err
+}
+
+func fromYAML(s string) (interface{}, error) {
+	var v interface{}
+	err := yaml.Unmarshal([]byte(s), &v)
+	return v, err
+}
+
+// defaultValue returns d, if v is empty
+func defaultValue(d, v interface{}) interface{} {
+	if isEmpty(v) {
+		return d
+	}
+	return v
+}
+
+// required fails rendering with msg, if v is empty
+func required(msg string, v interface{}) (interface{}, error) {
+	if isEmpty(v) {
+		return nil, fmt.Errorf("msg=\"required value is empty\" error=\"%v\"\n", msg)
+	}
+	return v, nil
+}
+
+// isEmpty checks whether v is nil or the zero value of its type, or an empty
+// collection
+func isEmpty(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return rv.Len() == 0
+	case reflect.Ptr, reflect.Interface:
+		return rv.IsNil()
+	}
+	return reflect.DeepEqual(v, reflect.Zero(rv.Type()).Interface())
+}
+
+// indent prefixes every line of s with spaces
+func indent(spaces int, s string) string {
+	pad := strings.Repeat(" ", spaces)
+	return pad + strings.Replace(s, "\n", "\n"+pad, -1)
+}
+
+// nindent is like indent, but starts with a newline
+func nindent(spaces int, s string) string {
+	return "\n" + indent(spaces, s)
+}
+
+// quote returns s as double quoted string with go escaping, which is valid
+// JSON and YAML for printable strings
+func quote(s string) string {
+	return fmt.Sprintf("%q", s)
+}
+
+// squote returns s single quoted, doubling contained single quotes as needed
+// by YAML
+func squote(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
+
+func sha256sum(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func bcryptHash(s string) (string, error) {
+	b, err := bcrypt.GenerateFromPassword([]byte(s), bcrypt.DefaultCost)
+	return string(b), err
+}
+
+// htpasswd returns a line for apache's htpasswd files with a bcrypt hash of
+// password
+func htpasswd(username, password string) (string, error) {
+	if strings.Contains(username, ":") {
+		return "", fmt.Errorf("msg=\"username must not contain colons\" username=\"%v\"\n", username)
+	}
+	hash, err := bcryptHash(password)
+	if err != nil {
+		return "", err
+	}
+	return username + ":" + hash, nil
+}
+
+// templateEnv returns the environment variable name of the secretsfs process.
+// As the environment may contain credentials of secretsfs itself, only the
+// variables in fio.templatefiles.allowedenv may be read.
+func templateEnv(name string) (string, error) {
+	for _, allowed := range viper.GetStringSlice("fio.templatefiles.allowedenv") {
+		if allowed == name {
+			return os.Getenv(name), nil
+		}
+	}
+	return "", fmt.Errorf("msg=\"environment variable is not in fio.templatefiles.allowedenv\" name=\"%v\"\n", name)
+}
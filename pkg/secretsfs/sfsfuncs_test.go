@@ -0,0 +1,86 @@
+package secretsfs
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+	"text/template"
+
+	"github.com/spf13/viper"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestTemplateFuncs(t *testing.T) {
+	tables := []struct {
+		tmpl string
+		data interface{}
+		want string
+	}{
+		{`{{ "secret" | b64enc }}`, nil, "c2VjcmV0"},
+		{`{{ "c2VjcmV0" | b64dec }}`, nil, "secret"},
+		{`{{ . | toJson }}`, map[string]interface{}{"a": []int{1, 2}}, `{"a":[1,2]}`},
+		{`{{ (fromJson .).a }}`, `{"a":"b"}`, "b"},
+		{`{{ . | default "fallback" }}`, "", "fallback"},
+		{`{{ . | default "fallback" }}`, "set", "set"},
+		{`{{ . | required "missing" }}`, "set", "set"},
+		{`{{ . | indent 2 }}`, "a\nb", "  a\n  b"},
+		{`x:{{ . | nindent 2 }}`, "a", "x:\n  a"},
+		{`{{ . | quote }}`, `say "hi"`, `"say \"hi\""`},
+		{`{{ . | squote }}`, `it's`, `'it''s'`},
+		{`{{ . | trim | upper }}`, " abc ", "ABC"},
+		{`{{ . | replace "-" "_" }}`, "a-b-c", "a_b_c"},
+		{`{{ . | split "," | join ";" }}`, "a,b", "a;b"},
+		{`{{ if . | hasPrefix "ab" }}yes{{ end }}`, "abc", "yes"},
+		{`{{ . | sha256sum }}`, "abc", "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"},
+	}
+	for _, table := range tables {
+		got, err := executeTestTemplate(table.tmpl, table.data)
+		if err != nil {
+			t.Errorf("got error for template '%v': %v\n", table.tmpl, err)
+			continue
+		}
+		if got != table.want {
+			t.Errorf("template '%v' was incorrect, got: '%v', want: '%v'\n", table.tmpl, got, table.want)
+		}
+	}
+
+	if _, err := executeTestTemplate(`{{ . | required "password is missing" }}`, ""); err == nil || !strings.Contains(err.Error(), "password is missing") {
+		t.Errorf("required didn't fail for empty value, got: '%v'\n", err)
+	}
+
+	line, err := executeTestTemplate(`{{ htpasswd "admin" . }}`, "secret")
+	if err != nil {
+		t.Fatalf("got error for htpasswd: %v\n", err)
+	}
+	parts := strings.SplitN(line, ":", 2)
+	if parts[0] != "admin" || bcrypt.CompareHashAndPassword([]byte(parts[1]), []byte("secret")) != nil {
+		t.Errorf("htpasswd line was incorrect, got: '%v'\n", line)
+	}
+}
+
+func TestTemplateEnv(t *testing.T) {
+	os.Setenv("SECRETSFS_TEST_ENV", "env")
+	os.Setenv("SECRETSFS_TEST_TOKEN", "token")
+	defer os.Unsetenv("SECRETSFS_TEST_ENV")
+	defer os.Unsetenv("SECRETSFS_TEST_TOKEN")
+	viper.Set("fio.templatefiles.allowedenv", []string{"SECRETSFS_TEST_ENV"})
+	defer viper.Set("fio.templatefiles.allowedenv", []string{})
+
+	if got, err := executeTestTemplate(`{{ env "SECRETSFS_TEST_ENV" }}`, nil); err != nil || got != "env" {
+		t.Errorf("allowed environment variable was incorrect, got: '%v', '%v', want: '%v'\n", got, err, "env")
+	}
+	if got, err := executeTestTemplate(`{{ env "SECRETSFS_TEST_TOKEN" }}`, nil); err == nil {
+		t.Errorf("read environment variable not allowed, got: '%v'\n", got)
+	}
+}
+
+func executeTestTemplate(tmpl string, data interface{}) (string, error) {
+	parser, err := template.New("test").Funcs(templateFuncs).Parse(tmpl)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	err = parser.Execute(&buf, data)
+	return buf.String(), err
+}
@@ -1,14 +1,32 @@
 package secretsfs
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"os"
+	"os/user"
 	"path/filepath"
 	"strings"
+	"syscall"
+	"text/template"
 
+	"github.com/hanwen/go-fuse/v2/fs"
 	"github.com/hanwen/go-fuse/v2/fuse"
+	log "github.com/sirupsen/logrus"
+
+	sfsfh "github.com/muryoutaisuu/secretsfs/pkg/fusehelpers"
 )
 
+// pathUser contains the placeholders available in path templates, e.g.
+//  users/{{.Username}}
+type pathUser struct {
+	Username string
+	Uid      string
+	Gid      string
+	Groups   []string
+}
+
 // pathsInodes contains all registered inodes so far, mapped to their paths
 var pathsInodes map[string]uint64 = make(map[string]uint64)
 
@@ -57,6 +75,55 @@ func rootName(npath string) (rootpath, subpath string) {
 	return
 }
 
+// subPathParts splits the subpath of npath into its parts
+func subPathParts(npath string) []string {
+	_, subpath := rootName(npath)
+	if subpath == "" {
+		return nil
+	}
+	return strings.Split(subpath, string(filepath.Separator))
+}
+
+// getDirEntry returns the fuse.DirEntry for name inside of directory npath
+func getDirEntry(npath, name string, mode uint32) fuse.DirEntry {
+	return fuse.DirEntry{
+		Name: name,
+		Ino:  GetInode(filepath.Join(npath, name)),
+		Mode: mode,
+	}
+}
+
+// contentHandle is a FileHandle containing the content rendered on Open, so
+// that all reads of an opened file return parts of the same content
+type contentHandle struct {
+	content []byte
+}
+
+// readResultAt returns the part of content requested by a read into dest at
+// offset off
+func readResultAt(content, dest []byte, off int64) fuse.ReadResult {
+	if off >= int64(len(content)) {
+		return fuse.ReadResultData(nil)
+	}
+	end := off + int64(len(dest))
+	if end > int64(len(content)) {
+		end = int64(len(content))
+	}
+	return fuse.ReadResultData(content[off:end])
+}
+
+// copyXattr copies value into dest as needed by getxattr and listxattr. An
+// empty dest only queries the size of value.
+func copyXattr(dest, value []byte) (uint32, syscall.Errno) {
+	if len(dest) == 0 {
+		return uint32(len(value)), fs.OK
+	}
+	if len(dest) < len(value) {
+		return uint32(len(value)), syscall.ERANGE
+	}
+	return uint32(copy(dest, value)), fs.OK
+}
+
 // GetMapStringKeys returns []string
 // containing all keys from a map[string]interface{}
 func GetMapStringKeys(m map[string]interface{}) []string {
@@ -77,6 +144,47 @@ func getModeFromFileInfo(fi os.FileInfo) uint32 {
 	return fuse.S_IFREG
 }
 
+// newPathUser returns the pathUser of the user calling the filesystem operation
+func newPathUser(ctx context.Context) (*pathUser, error) {
+	u, err := sfsfh.GetUserFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	pu := &pathUser{
+		Username: u.Username,
+		Uid:      u.Uid,
+		Gid:      u.Gid,
+	}
+	gids, err := u.GroupIds()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"username": u.Username,
+			"error":    err,
+			"calling":  "u.GroupIds()"}).Warn("got error while getting all usergroupids from user")
+	}
+	for _, gid := range gids {
+		g, err := user.LookupGroupId(gid)
+		if err != nil {
+			continue
+		}
+		pu.Groups = append(pu.Groups, g.Name)
+	}
+	return pu, nil
+}
+
+// renderPathTemplate resolves the placeholders of tmpl with pu
+func renderPathTemplate(tmpl string, pu *pathUser) (string, error) {
+	t, err := template.New("path").Option("missingkey=error").Parse(tmpl)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, pu); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
 // PrettyPrint variable (struct, map, array, slice) in Golang
 // https://siongui.github.io/2016/01/30/go-pretty-print-variable/
 func PrettyPrint(v interface{}) ([]byte, error) {
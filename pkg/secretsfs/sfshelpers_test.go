@@ -27,3 +27,56 @@ func TestRootName(t *testing.T) {
 		}
 	}
 }
+
+func TestRenderPathTemplate(t *testing.T) {
+	pu := &pathUser{
+		Username: "alice",
+		Uid:      "1000",
+		Gid:      "100",
+		Groups:   []string{"users", "admin"},
+	}
+	tables := []struct {
+		tmpl string
+		want string
+	}{
+		{"users/{{.Username}}", "users/alice"},
+		{"uids/{{.Uid}}/{{.Gid}}", "uids/1000/100"},
+		{"groups/{{index .Groups 1}}", "groups/admin"},
+		{"static/path", "static/path"},
+	}
+	for _, table := range tables {
+		got, err := renderPathTemplate(table.tmpl, pu)
+		if err != nil {
+			t.Errorf("got error for tmpl='%v': %v\n", table.tmpl, err)
+			continue
+		}
+		if got != table.want {
+			t.Errorf("path of '%v' was incorrect, got: '%v', want: '%v'\n", table.tmpl, got, table.want)
+		}
+	}
+	if _, err := renderPathTemplate("users/{{.Unknown}}", pu); err == nil {
+		t.Errorf("expected error for unknown placeholder\n")
+	}
+}
+
+func TestReadResultAt(t *testing.T) {
+	content := []byte("123456\n")
+	tables := []struct {
+		size int
+		off  int64
+		want string
+	}{
+		{4096, 0, "123456\n"},
+		{3, 0, "123"},
+		{3, 3, "456"},
+		{4096, 6, "\n"},
+		{4096, 7, ""},
+		{4096, 100, ""},
+	}
+	for _, table := range tables {
+		got, _ := readResultAt(content, make([]byte, table.size), table.off).Bytes(nil)
+		if string(got) != table.want {
+			t.Errorf("read of %v bytes at '%v' was incorrect, got: '%v', want: '%v'\n", table.size, table.off, string(got), table.want)
+		}
+	}
+}
@@ -133,6 +133,107 @@ func (n *SfsNode) Read(ctx context.Context, fh fs.FileHandle, dest []byte, off i
 	return fr.Read(n, ctx, fh, dest, off)
 }
 
+// Write File
+var _ = (fs.NodeWriter)((*SfsNode)(nil))
+
+func (n *SfsNode) Write(ctx context.Context, fh fs.FileHandle, data []byte, off int64) (written uint32, errno syscall.Errno) {
+	log.WithFields(log.Fields{"n": n, "n.npath": n.npath, "off": off}).Debug("log values")
+	rootpath, _ := rootName(n.npath)
+	fr := getFIORootFromRootPath(rootpath)
+	if w, ok := fr.(FIOWriter); ok {
+		log.WithFields(log.Fields{
+			"n":            n,
+			"n.npath":      n.npath,
+			"rootpath":     rootpath,
+			"fr.FIOPath()": fr.FIOPath()}).Debug("delegating Write to FIORoot")
+		return w.Write(n, ctx, fh, data, off)
+	}
+	return 0, syscall.ENOTSUP
+}
+
+// Release File
+var _ = (fs.NodeReleaser)((*SfsNode)(nil))
+
+func (n *SfsNode) Release(ctx context.Context, fh fs.FileHandle) syscall.Errno {
+	log.WithFields(log.Fields{"n": n, "n.npath": n.npath}).Debug("log values")
+	rootpath, _ := rootName(n.npath)
+	fr := getFIORootFromRootPath(rootpath)
+	if rel, ok := fr.(FIOReleaser); ok {
+		log.WithFields(log.Fields{
+			"n":            n,
+			"n.npath":      n.npath,
+			"rootpath":     rootpath,
+			"fr.FIOPath()": fr.FIOPath()}).Debug("delegating Release to FIORoot")
+		return rel.Release(n, ctx, fh)
+	}
+	return fs.OK
+}
+
+// Readlink
+var _ = (fs.NodeReadlinker)((*SfsNode)(nil))
+
+func (n *SfsNode) Readlink(ctx context.Context) ([]byte, syscall.Errno) {
+	log.WithFields(log.Fields{"n": n, "n.npath": n.npath}).Debug("log values")
+	rootpath, _ := rootName(n.npath)
+	fr := getFIORootFromRootPath(rootpath)
+	if rl, ok := fr.(FIOReadlinker); ok {
+		log.WithFields(log.Fields{
+			"n":            n,
+			"n.npath":      n.npath,
+			"rootpath":     rootpath,
+			"fr.FIOPath()": fr.FIOPath()}).Debug("delegating Readlink to FIORoot")
+		return rl.Readlink(n, ctx)
+	}
+	return nil, syscall.EINVAL
+}
+
+// Getxattr
+var _ = (fs.NodeGetxattrer)((*SfsNode)(nil))
+
+func (n *SfsNode) Getxattr(ctx context.Context, attr string, dest []byte) (uint32, syscall.Errno) {
+	log.WithFields(log.Fields{"n": n, "n.npath": n.npath, "attr": attr}).Debug("log values")
+	xattrs, errno := n.xattrs(ctx)
+	if errno != fs.OK {
+		return 0, errno
+	}
+	value, ok := xattrs[attr]
+	if !ok {
+		return 0, syscall.ENODATA
+	}
+	return copyXattr(dest, value)
+}
+
+// Listxattr
+var _ = (fs.NodeListxattrer)((*SfsNode)(nil))
+
+func (n *SfsNode) Listxattr(ctx context.Context, dest []byte) (uint32, syscall.Errno) {
+	log.WithFields(log.Fields{"n": n, "n.npath": n.npath}).Debug("log values")
+	xattrs, errno := n.xattrs(ctx)
+	if errno != fs.OK {
+		return 0, errno
+	}
+	var names []byte
+	for _, name := range sortedByteKeys(xattrs) {
+		names = append(append(names, name...), 0)
+	}
+	return copyXattr(dest, names)
+}
+
+// xattrs returns the extended attributes of n shown by its FIORoot
+func (n *SfsNode) xattrs(ctx context.Context) (map[string][]byte, syscall.Errno) {
+	rootpath, _ := rootName(n.npath)
+	fr := getFIORootFromRootPath(rootpath)
+	if x, ok := fr.(FIOXattrer); ok {
+		log.WithFields(log.Fields{
+			"n":            n,
+			"n.npath":      n.npath,
+			"rootpath":     rootpath,
+			"fr.FIOPath()": fr.FIOPath()}).Debug("delegating Xattrs to FIORoot")
+		return x.Xattrs(n, ctx)
+	}
+	return nil, fs.OK
+}
+
 // Lookup Node
 var _ = (fs.NodeLookuper)((*SfsNode)(nil))
 
@@ -227,6 +328,25 @@ func (n *SfsNode) Getattr(ctx context.Context, fh fs.FileHandle, out *fuse.AttrO
 	return fr.Getattr(n, ctx, fh, out)
 }
 
+// SetAttrer
+var _ = (fs.NodeSetattrer)((*SfsNode)(nil))
+
+func (n *SfsNode) Setattr(ctx context.Context, fh fs.FileHandle, in *fuse.SetAttrIn, out *fuse.AttrOut) syscall.Errno {
+	log.WithFields(log.Fields{"n": n, "n.npath": n.npath}).Debug("log values")
+	rootpath, _ := rootName(n.npath)
+	fr := getFIORootFromRootPath(rootpath)
+	if sa, ok := fr.(FIOSetattrer); ok {
+		log.WithFields(log.Fields{
+			"n":            n,
+			"n.npath":      n.npath,
+			"rootpath":     rootpath,
+			"fr.FIOPath()": fr.FIOPath()}).Debug("delegating Setattr to FIORoot")
+		return sa.Setattr(n, ctx, fh, in, out)
+	}
+	// attributes can't be changed, just return the current ones
+	return n.Getattr(ctx, fh, out)
+}
+
 // OnAdder
 var _ = (fs.NodeOnAdder)((*SfsNode)(nil))
 
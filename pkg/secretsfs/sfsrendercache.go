@@ -0,0 +1,107 @@
+package secretsfs
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+	log "github.com/sirupsen/logrus"
+)
+
+// renderCacheKey identifies a templatefile rendered for a user. Changing the
+// template changes its modification time and therefore the key, changes of
+// partials are detected by renderCacheEntry.files.
+type renderCacheKey struct {
+	uid   uint32
+	tpath string
+	mtime time.Time
+}
+
+// renderCacheEntry is a cached rendered templatefile
+type renderCacheEntry struct {
+	content []byte
+	expires time.Time
+	// refs maps the secrets referenced while rendering to fingerprints of
+	// their values
+	refs map[string]string
+	// files maps the partials and the directories searched for partials to
+	// their modification times, so that modified, added or removed partials
+	// invalidate the entry
+	files map[string]time.Time
+}
+
+// renderCache caches rendered templatefiles, so that Getattr and Read don't
+// render twice. Entries expire after fio.templatefiles.cachettl, or as soon
+// as secretsfs sees another value for a referenced secret.
+type renderCache struct {
+	mu      sync.Mutex
+	entries map[renderCacheKey]*renderCacheEntry
+}
+
+var templateCache = &renderCache{entries: make(map[renderCacheKey]*renderCacheEntry)}
+
+// newRenderCacheKey returns the key of tpath rendered for the caller of ctx.
+// Renders without calling user aren't cacheable.
+func newRenderCacheKey(tpath string, mtime time.Time, ctx context.Context) (renderCacheKey, bool) {
+	c, ok := ctx.(*fuse.Context)
+	if !ok {
+		return renderCacheKey{}, false
+	}
+	return renderCacheKey{uid: c.Caller.Owner.Uid, tpath: tpath, mtime: mtime}, true
+}
+
+// get returns the content cached for key, if it didn't expire until now and
+// none of its partials changed
+func (c *renderCache) get(key renderCacheKey, now time.Time) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok || !now.Before(e.expires) {
+		return nil, false
+	}
+	if filesChanged(e.files) {
+		log.WithFields(log.Fields{"tpath": key.tpath, "uid": key.uid}).Debug("partials changed, invalidating rendered templatefile")
+		delete(c.entries, key)
+		return nil, false
+	}
+	return e.content, true
+}
+
+// put caches content for key until now+ttl, a ttl <= 0 disables caching.
+// Expired entries and entries of older versions of the template are removed.
+func (c *renderCache) put(key renderCacheKey, content []byte, refs map[string]string, files map[string]time.Time, now time.Time, ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k, e := range c.entries {
+		if !now.Before(e.expires) || (k.uid == key.uid && k.tpath == key.tpath) {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = &renderCacheEntry{content: content, expires: now.Add(ttl), refs: refs, files: files}
+}
+
+// secretSeen removes all entries, which saw another value for ref than the
+// value with fingerprint fp
+func (c *renderCache) secretSeen(ref, fp string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k, e := range c.entries {
+		if old, ok := e.refs[ref]; ok && old != fp {
+			log.WithFields(log.Fields{"tpath": k.tpath, "uid": k.uid, "ref": ref}).Debug("referenced secret changed, invalidating rendered templatefile")
+			delete(c.entries, k)
+		}
+	}
+}
+
+// fingerprint returns a hash of value, so that the cache doesn't keep
+// secrets besides rendered content
+func fingerprint(value []byte) string {
+	sum := sha256.Sum256(value)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,100 @@
+package secretsfs
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"github.com/spf13/viper"
+)
+
+func TestRenderCache(t *testing.T) {
+	c := &renderCache{entries: make(map[renderCacheKey]*renderCacheEntry)}
+	now := time.Now()
+	mtime := now.Add(-time.Hour)
+	alice := renderCacheKey{uid: 1000, tpath: "/templates/app.conf", mtime: mtime}
+	bob := renderCacheKey{uid: 1001, tpath: "/templates/app.conf", mtime: mtime}
+
+	c.put(alice, []byte("alice"), map[string]string{"app/password": fingerprint([]byte("old"))}, nil, now, time.Minute)
+	c.put(bob, []byte("bob"), map[string]string{"app/username": fingerprint([]byte("bob"))}, nil, now, time.Minute)
+
+	if content, ok := c.get(alice, now.Add(time.Second)); !ok || string(content) != "alice" {
+		t.Errorf("cached content was incorrect, got: '%v', want: '%v'\n", string(content), "alice")
+	}
+	if _, ok := c.get(alice, now.Add(time.Minute)); ok {
+		t.Errorf("expired entry was served\n")
+	}
+	changed := alice
+	changed.mtime = now
+	if _, ok := c.get(changed, now); ok {
+		t.Errorf("entry of changed template was served\n")
+	}
+
+	// seeing the same value keeps the entry, another value invalidates it
+	c.secretSeen("app/password", fingerprint([]byte("old")))
+	if _, ok := c.get(alice, now); !ok {
+		t.Errorf("entry was invalidated by unchanged secret\n")
+	}
+	c.secretSeen("app/password", fingerprint([]byte("new")))
+	if _, ok := c.get(alice, now); ok {
+		t.Errorf("entry wasn't invalidated by changed secret\n")
+	}
+	if _, ok := c.get(bob, now); !ok {
+		t.Errorf("entry not referencing the changed secret was invalidated\n")
+	}
+
+	// a new version of the template replaces the old one
+	c.put(changed, []byte("alice"), nil, nil, now, time.Minute)
+	c.put(alice, []byte("alice"), nil, nil, now, 0)
+	if len(c.entries) != 2 {
+		t.Errorf("number of entries was incorrect, got: '%v', want: '%v'\n", len(c.entries), 2)
+	}
+}
+
+func TestRenderCachePartials(t *testing.T) {
+	viper.Set("fio.templatefiles.cachettl", "1m")
+	defer viper.Set("fio.templatefiles.cachettl", "30s")
+	root, err := ioutil.TempDir("", "templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	previous := TEMPLATESPATHS
+	TEMPLATESPATHS = map[string]string{"test": root}
+	defer func() { TEMPLATESPATHS = previous }()
+
+	write := func(name, content string, mtime time.Time) {
+		p := filepath.Join(root, name)
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(p, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	past := time.Now().Add(-time.Hour)
+	write("app.conf", `{{ template "tls" }}`, past)
+	write("tls.tpl", "old", past)
+
+	var ctx context.Context = &fuse.Context{Caller: fuse.Caller{Owner: fuse.Owner{Uid: 4711}}}
+	render := func() string {
+		content, err := renderTemplatefile("test", filepath.Join(root, "app.conf"), nil, &ctx)
+		if err != nil {
+			t.Fatalf("got error while rendering: %v\n", err)
+		}
+		return string(content)
+	}
+	if got := render(); got != "old" {
+		t.Fatalf("rendered template was incorrect, got: '%v', want: '%v'\n", got, "old")
+	}
+	// a modified partial renders the template again, although the template
+	// itself is unchanged
+	write("tls.tpl", "new", past.Add(time.Minute))
+	if got := render(); got != "new" {
+		t.Errorf("rendered template with modified partial was incorrect, got: '%v', want: '%v'\n", got, "new")
+	}
+}
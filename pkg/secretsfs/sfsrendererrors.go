@@ -0,0 +1,112 @@
+package secretsfs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// renderErrorSuffix is appended to templatefiles to get their last render
+// error
+const renderErrorSuffix = ".error"
+
+// renderErrorKey identifies a templatefile rendered for a user
+type renderErrorKey struct {
+	uid   uint32
+	tpath string
+}
+
+// renderErrors contains the last render error per user and templatefile,
+// redacted so that they may be shown to the user
+type renderErrors struct {
+	mu       sync.Mutex
+	messages map[renderErrorKey][]byte
+}
+
+var templateErrors = &renderErrors{messages: make(map[renderErrorKey][]byte)}
+
+// newRenderErrorKey returns the key of tpath for the caller of ctx
+func newRenderErrorKey(tpath string, ctx context.Context) (renderErrorKey, bool) {
+	c, ok := ctx.(*fuse.Context)
+	if !ok {
+		return renderErrorKey{}, false
+	}
+	return renderErrorKey{uid: c.Caller.Owner.Uid, tpath: tpath}, true
+}
+
+// set stores message as last error of key, nil clears it
+func (r *renderErrors) set(key renderErrorKey, message []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if message == nil {
+		delete(r.messages, key)
+		return
+	}
+	r.messages[key] = message
+}
+
+func (r *renderErrors) get(key renderErrorKey) ([]byte, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	message, ok := r.messages[key]
+	return message, ok
+}
+
+// templateErrorLocation matches the location of errors of text/template,
+// e.g. template: app.conf:3:14: executing "app.conf" at <.Get>: ...
+var templateErrorLocation = regexp.MustCompile(`template: ([^:]+):(\d+)(?::\d+)?: (.*)`)
+
+// templateErrorCall matches failed function calls in errors of
+// text/template, which contain errors of the store after the name
+var templateErrorCall = regexp.MustCompile(`error calling (\w+)`)
+
+// redactRenderError returns err of rendering the templatefile at tpath as
+// shown to users: name, the failing line and the failing function, but no
+// errors of the store or values of secrets.
+func redactRenderError(name, tpath string, err error) []byte {
+	var buf bytes.Buffer
+	var verr *outputValidationError
+	if errors.As(err, &verr) {
+		fmt.Fprintf(&buf, "%s: rendered output is no valid %s", name, verr.format)
+		if verr.line > 0 {
+			fmt.Fprintf(&buf, " in line %d", verr.line)
+		}
+		buf.WriteString("\n")
+		return buf.Bytes()
+	}
+	m := templateErrorLocation.FindStringSubmatch(strings.SplitN(err.Error(), "\n", 2)[0])
+	if m == nil {
+		fmt.Fprintf(&buf, "%s: rendering failed\n", name)
+		return buf.Bytes()
+	}
+	// parse errors are wrapped into a quoted error field
+	reason := strings.TrimSuffix(m[3], "\"")
+	if strings.HasPrefix(reason, "executing ") {
+		reason = "executing failed"
+		if call := templateErrorCall.FindStringSubmatch(m[3]); call != nil {
+			reason = call[0]
+		}
+	}
+	if m[1] != filepath.Base(tpath) {
+		// the error is located in a partial
+		fmt.Fprintf(&buf, "%s: %s:%s: %s\n", name, m[1], m[2], reason)
+		return buf.Bytes()
+	}
+	fmt.Fprintf(&buf, "%s:%s: %s\n", name, m[2], reason)
+	line, _ := strconv.Atoi(m[2])
+	if content, err := ioutil.ReadFile(tpath); err == nil {
+		if lines := strings.Split(string(content), "\n"); line > 0 && line <= len(lines) {
+			fmt.Fprintf(&buf, "%5d | %s\n", line, lines[line-1])
+		}
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,102 @@
+package secretsfs
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+
+	"github.com/muryoutaisuu/secretsfs/pkg/store"
+)
+
+func TestRedactRenderError(t *testing.T) {
+	active := store.GetStore()
+	previous := *active
+	*active = store.Map{}
+	defer func() { *active = previous }()
+
+	dir, err := ioutil.TempDir("", "templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ctx := context.Background()
+	tables := []struct {
+		content string
+		want    string
+	}{
+		{"---\n{\"mode\": \"0400\"}\n---\nuser = admin\npassword = {{ .Get \"apps/db/missing\" }}\n",
+			"default/app.conf:5: error calling Get\n    5 | password = {{ .Get \"apps/db/missing\" }}\n"},
+		{"a = 1\nb = {{ end }}\n", "default/app.conf:2: unexpected {{end}}\n    2 | b = {{ end }}\n"},
+		{"{{ template \"missing\" }}", "default/app.conf:1: executing failed\n    1 | {{ template \"missing\" }}\n"},
+	}
+	tpath := filepath.Join(dir, "app.conf")
+	for _, table := range tables {
+		if err := ioutil.WriteFile(tpath, []byte(table.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		_, err := executeTemplatefile(dir, tpath, secret{ctx: &ctx})
+		if err == nil {
+			t.Errorf("expected error for '%v'\n", table.content)
+			continue
+		}
+		if got := string(redactRenderError("default/app.conf", tpath, err)); got != table.want {
+			t.Errorf("redacted error was incorrect, got: '%v', want: '%v'\n", got, table.want)
+		}
+	}
+
+	if got := string(redactRenderError("default/app.conf", tpath, errors.New("secret value in store error"))); got != "default/app.conf: rendering failed\n" {
+		t.Errorf("redacted error was incorrect, got: '%v'\n", got)
+	}
+}
+
+func TestGetRenderErrorAccess(t *testing.T) {
+	dir, err := ioutil.TempDir("", "templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"app.conf": "password = {{ .Get \"apps/db/password\" }}\n",
+		"ops.conf": "---\n{\"groups\": [\"secretsfs-no-such-group\"]}\n---\npassword = {{ .Get \"apps/db/password\" }}\n",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	previous := TEMPLATESPATHS
+	TEMPLATESPATHS = map[string]string{"default": dir}
+	defer func() { TEMPLATESPATHS = previous }()
+
+	ctx := &fuse.Context{Caller: fuse.Caller{Owner: fuse.Owner{Uid: 0, Gid: 0}}}
+	tables := []struct {
+		utemplp string
+		errno   syscall.Errno
+	}{
+		{"app.conf.error", fs.OK},
+		// error files require access to their templatefile
+		{"ops.conf.error", syscall.EACCES},
+	}
+	for _, table := range tables {
+		key, _ := newRenderErrorKey(filepath.Join(dir, strings.TrimSuffix(table.utemplp, renderErrorSuffix)), ctx)
+		templateErrors.set(key, []byte("default/app.conf:1: error calling Get\n"))
+		defer templateErrors.set(key, nil)
+
+		message, ok, errno := getRenderError("default", table.utemplp, ctx)
+		if !ok || errno != table.errno {
+			t.Errorf("error file '%v' was incorrect, got: '%v', '%v', want: '%v', '%v'\n", table.utemplp, ok, errno, true, table.errno)
+		}
+		if errno != fs.OK && message != nil {
+			t.Errorf("error file '%v' was served without access, got: '%v'\n", table.utemplp, string(message))
+		}
+	}
+}
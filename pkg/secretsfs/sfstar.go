@@ -0,0 +1,143 @@
+package secretsfs
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"path/filepath"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	sfsfh "github.com/muryoutaisuu/secretsfs/pkg/fusehelpers"
+	"github.com/muryoutaisuu/secretsfs/pkg/store"
+)
+
+// tarSuffix is appended to directories to get their virtual tar archive
+const tarSuffix = ".tar"
+
+// tarStream generates the tar archive of a subtree in a store on demand, one
+// secret at a time, so that the archive is never kept in memory as a whole.
+// All entries are read with the context of the read requesting them, entries
+// the user isn't allowed to read are left out.
+type tarStream struct {
+	mu      sync.Mutex
+	sto     store.Store
+	spath   string
+	name    string
+	modtime time.Time
+
+	tw *tar.Writer
+	// buf contains the generated bytes, which were not dropped yet
+	buf bytes.Buffer
+	// pos is the offset of the first byte of buf in the archive
+	pos int64
+	// pending contains the secrets still to be added, the last one is next
+	pending []tarItem
+	done    bool
+}
+
+// tarItem is a secret at spath to be added to a tar archive as name
+type tarItem struct {
+	spath string
+	name  string
+}
+
+// newTarStream returns the tar archive of the subtree at spath in sto. name is
+// the name of the top directory inside of the archive.
+func newTarStream(sto store.Store, spath, name string) *tarStream {
+	s := &tarStream{sto: sto, spath: spath, name: name, modtime: time.Now()}
+	s.reset()
+	return s
+}
+
+// reset starts generating the archive from its beginning
+func (s *tarStream) reset() {
+	s.buf.Reset()
+	s.tw = tar.NewWriter(&s.buf)
+	s.pos = 0
+	s.pending = []tarItem{{spath: s.spath, name: s.name}}
+	s.done = false
+}
+
+// readAt returns up to size bytes of the archive at off. Reading before the
+// last read offset generates the archive again from its beginning.
+func (s *tarStream) readAt(off int64, size int, ctx context.Context) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if off < s.pos {
+		s.reset()
+	}
+	for {
+		// drop everything before off, it won't be read again
+		if skip := off - s.pos; skip > 0 {
+			if skip > int64(s.buf.Len()) {
+				skip = int64(s.buf.Len())
+			}
+			s.buf.Next(int(skip))
+			s.pos += skip
+		}
+		if s.done || (s.pos == off && s.buf.Len() >= size) {
+			break
+		}
+		if err := s.next(ctx); err != nil {
+			return nil, err
+		}
+	}
+	b := s.buf.Bytes()
+	if off > s.pos || len(b) == 0 {
+		return nil, nil
+	}
+	if len(b) > size {
+		b = b[:size]
+	}
+	return append([]byte{}, b...), nil
+}
+
+// next adds the next pending secret to the archive, or finishes the archive
+// if there are none left
+func (s *tarStream) next(ctx context.Context) error {
+	if len(s.pending) == 0 {
+		s.done = true
+		return s.tw.Close()
+	}
+	item := s.pending[len(s.pending)-1]
+	s.pending = s.pending[:len(s.pending)-1]
+
+	sec, err := s.sto.GetSecret(item.spath, ctx)
+	if err != nil {
+		log.WithFields(log.Fields{"spath": item.spath, "error": err}).Debug("leaving out secret in tar archive")
+		return nil
+	}
+	hdr := &tar.Header{
+		Name:    item.name,
+		ModTime: s.modtime,
+	}
+	switch {
+	case sfsfh.IsDir(sec.Mode):
+		hdr.Typeflag = tar.TypeDir
+		hdr.Name += "/"
+		hdr.Mode = sfsfh.Perm(sec.Mode)
+		if err := s.tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		// pushed in reverse order, so that subs are added in their order
+		for i := len(sec.Subs) - 1; i >= 0; i-- {
+			sub := sec.Subs[i]
+			s.pending = append(s.pending, tarItem{spath: sub.Path, name: filepath.Join(item.name, filepath.Base(sub.Path))})
+		}
+	case sfsfh.IsFile(sec.Mode):
+		hdr.Typeflag = tar.TypeReg
+		hdr.Mode = sfsfh.Perm(sec.Mode)
+		hdr.Size = int64(len(sec.Content))
+		if err := s.tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := s.tw.Write(sec.Content); err != nil {
+			return err
+		}
+		return s.tw.Flush()
+	}
+	return nil
+}
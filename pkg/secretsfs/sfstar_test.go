@@ -0,0 +1,86 @@
+package secretsfs
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	sfsfh "github.com/muryoutaisuu/secretsfs/pkg/fusehelpers"
+	"github.com/muryoutaisuu/secretsfs/pkg/store"
+)
+
+func TestTarStream(t *testing.T) {
+	sto := store.Map{
+		"app": {Path: "app", Mode: sfsfh.DIRREAD, Subs: []*store.Secret{
+			{Path: "app/certs", Mode: sfsfh.DIRREAD},
+			{Path: "app/private", Mode: sfsfh.DIRREAD},
+			{Path: "app/password", Mode: sfsfh.FILEREAD},
+		}},
+		"app/certs": {Path: "app/certs", Mode: sfsfh.DIRREAD, Subs: []*store.Secret{
+			{Path: "app/certs/ca.pem", Mode: sfsfh.FILEREAD},
+		}},
+		"app/certs/ca.pem": {Path: "app/certs/ca.pem", Mode: sfsfh.FILENOREAD, Content: []byte("-----BEGIN CERTIFICATE-----\n")},
+		"app/password":     {Path: "app/password", Mode: sfsfh.FILEREAD, Content: bytes.Repeat([]byte("secret"), 200)},
+	}
+	s := newTarStream(sto, "app", "app")
+	b, err := s.readAt(0, 1<<20, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	whole := bytes.NewBuffer(b)
+	size := int64(whole.Len())
+
+	tables := []struct {
+		name    string
+		mode    int64
+		content string
+	}{
+		{"app/", 0755, ""},
+		{"app/certs/", 0755, ""},
+		{"app/certs/ca.pem", 0700, "-----BEGIN CERTIFICATE-----\n"},
+		{"app/password", 0755, string(bytes.Repeat([]byte("secret"), 200))},
+	}
+	tr := tar.NewReader(bytes.NewReader(whole.Bytes()))
+	for _, table := range tables {
+		hdr, err := tr.Next()
+		if err != nil {
+			t.Fatalf("got error while reading entry '%v': %v\n", table.name, err)
+		}
+		content, _ := ioutil.ReadAll(tr)
+		if hdr.Name != table.name || hdr.Mode != table.mode || string(content) != table.content {
+			t.Errorf("entry was incorrect, got: '%v' '%o' '%v', want: '%v' '%o' '%v'\n", hdr.Name, hdr.Mode, string(content), table.name, table.mode, table.content)
+		}
+	}
+	if hdr, err := tr.Next(); err != io.EOF {
+		t.Errorf("expected end of archive, got: '%v'\n", hdr)
+	}
+
+	// reads in small chunks, skipping ahead and going back must return the
+	// same archive
+	reads := []struct {
+		off  int64
+		size int
+	}{
+		{0, 100}, {100, 700}, {800, 512}, {3000, 10}, {1312, 1688}, {3010, 100000}, {0, 10}, {size, 10},
+	}
+	for _, r := range reads {
+		got, err := s.readAt(r.off, r.size, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		end := r.off + int64(r.size)
+		if end > size {
+			end = size
+		}
+		want := []byte{}
+		if r.off < size {
+			want = whole.Bytes()[r.off:end]
+		}
+		// modification times are equal, as they are set once per stream
+		if !bytes.Equal(got, want) {
+			t.Errorf("read of %v bytes at '%v' was incorrect, got %v bytes, want %v bytes\n", r.size, r.off, len(got), len(want))
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package secretsfs
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// templateACL is an entry of fio.templatefiles.acls
+type templateACL struct {
+	// Path is a templatespath or a template in it, as shown below
+	// templatefiles/, e.g. default or default/app.conf
+	Path  string
+	Allow templateACLRule
+	Deny  templateACLRule
+}
+
+// templateACLRule matches callers by user, group or executable
+type templateACLRule struct {
+	Users  []string
+	Groups []string
+	// Executables contains paths or patterns of the calling executable, e.g.
+	// /usr/lib/jvm/*/bin/java
+	Executables []string
+}
+
+// templateCaller describes the process reading a templatefile
+type templateCaller struct {
+	*pathUser
+	pid uint32
+	exe string
+}
+
+// newTemplateCaller returns the caller of ctx
+func newTemplateCaller(ctx context.Context) (*templateCaller, error) {
+	c, ok := ctx.(*fuse.Context)
+	if !ok {
+		return nil, fmt.Errorf("msg=\"context contains no caller\"\n")
+	}
+	pu, err := newPathUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+	tc := &templateCaller{pathUser: pu, pid: c.Caller.Pid}
+	// processes may have exited already, so a missing executable only fails
+	// rules matching executables
+	tc.exe, _ = os.Readlink(fmt.Sprintf("/proc/%d/exe", c.Caller.Pid))
+	return tc, nil
+}
+
+// checkTemplateAccess checks the ACLs applying to tpath, the path of a
+// templatefile below templatefiles/, for the caller of ctx. Denials are
+// written to the audit log.
+func checkTemplateAccess(tpath string, ctx context.Context) syscall.Errno {
+	return checkACLs("templatefiles", tpath, ctx)
+}
+
+// checkACLs checks the ACLs in fio.<fio>.acls applying to tpath, the path of
+// a template below the directory of the FIO fio, for the caller of ctx.
+func checkACLs(fio, tpath string, ctx context.Context) syscall.Errno {
+	var acls []templateACL
+	if err := viper.UnmarshalKey("fio."+fio+".acls", &acls); err != nil {
+		log.WithFields(log.Fields{"error": err}).Error("got error while reading fio." + fio + ".acls")
+		return syscall.EACCES
+	}
+	var applying []templateACL
+	for _, acl := range acls {
+		if acl.appliesTo(tpath) {
+			applying = append(applying, acl)
+		}
+	}
+	if len(applying) == 0 {
+		return fs.OK
+	}
+	c, err := newTemplateCaller(ctx)
+	if err != nil {
+		log.WithFields(log.Fields{"audit": fio, "tpath": tpath, "error": err}).Warn("denied access to templatefile, caller is unknown")
+		return syscall.EACCES
+	}
+	for _, acl := range applying {
+		if reason, ok := acl.permits(c); !ok {
+			log.WithFields(log.Fields{
+				"audit":    fio,
+				"tpath":    tpath,
+				"acl":      acl.Path,
+				"reason":   reason,
+				"username": c.Username,
+				"uid":      c.Uid,
+				"pid":      c.pid,
+				"exe":      c.exe}).Warn("denied access to templatefile")
+			return syscall.EACCES
+		}
+	}
+	return fs.OK
+}
+
+// appliesTo checks whether acl applies to tpath. ACLs with path "/" or an
+// empty path apply to all templates.
+func (acl templateACL) appliesTo(tpath string) bool {
+	p := strings.Trim(filepath.Clean("/"+acl.Path), "/")
+	return p == "" || tpath == p || strings.HasPrefix(tpath, p+"/")
+}
+
+// permits checks the rules of acl for c. Deny rules win over allow rules,
+// and if allow rules are set, c must match one of them.
+func (acl templateACL) permits(c *templateCaller) (reason string, ok bool) {
+	if acl.Deny.matches(c) {
+		return "matched deny rule", false
+	}
+	if !acl.Allow.empty() && !acl.Allow.matches(c) {
+		return "matched no allow rule", false
+	}
+	return "", true
+}
+
+func (r templateACLRule) empty() bool {
+	return len(r.Users) == 0 && len(r.Groups) == 0 && len(r.Executables) == 0
+}
+
+// matches checks whether c matches any user, group or executable of r
+func (r templateACLRule) matches(c *templateCaller) bool {
+	for _, u := range r.Users {
+		if u == c.Username {
+			return true
+		}
+	}
+	for _, g := range r.Groups {
+		for _, ug := range c.Groups {
+			if g == ug {
+				return true
+			}
+		}
+	}
+	for _, e := range r.Executables {
+		if matched, _ := filepath.Match(e, c.exe); matched && c.exe != "" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,64 @@
+package secretsfs
+
+import (
+	"testing"
+)
+
+func TestTemplateACL(t *testing.T) {
+	appl := &templateCaller{pathUser: &pathUser{Username: "appl", Groups: []string{"appl"}}, exe: "/usr/lib/jvm/java-11/bin/java"}
+	alice := &templateCaller{pathUser: &pathUser{Username: "alice", Groups: []string{"users", "ops"}}, exe: "/usr/bin/cat"}
+	mallory := &templateCaller{pathUser: &pathUser{Username: "mallory", Groups: []string{"users"}}, exe: "/usr/bin/cat"}
+
+	acl := templateACL{
+		Path: "default/app.conf",
+		Allow: templateACLRule{
+			Groups:      []string{"ops"},
+			Executables: []string{"/usr/lib/jvm/*/bin/java"},
+		},
+		Deny: templateACLRule{
+			Users: []string{"alice"},
+		},
+	}
+	tables := []struct {
+		caller  *templateCaller
+		permits bool
+	}{
+		{appl, true},
+		{alice, false},
+		{mallory, false},
+	}
+	for _, table := range tables {
+		if _, ok := acl.permits(table.caller); ok != table.permits {
+			t.Errorf("permits for '%v' was incorrect, got: '%v', want: '%v'\n", table.caller.Username, ok, table.permits)
+		}
+	}
+
+	// without allow rules, everybody not denied is permitted
+	denyonly := templateACL{Path: "default", Deny: templateACLRule{Groups: []string{"users"}}}
+	if _, ok := denyonly.permits(appl); !ok {
+		t.Errorf("caller not matching deny rule was denied\n")
+	}
+
+	paths := []struct {
+		aclpath string
+		tpath   string
+		applies bool
+	}{
+		{"default", "default", true},
+		{"default", "default/app.conf", true},
+		{"default/", "default/sub/app.conf", true},
+		{"default", "defaults/app.conf", false},
+		{"default/app.conf", "default/app.conf", true},
+		{"default/app.conf", "default/app.conf.bak", false},
+		// global ACLs apply to every template
+		{"/", "default/app.conf", true},
+		{"", "default", true},
+		{".", "applA/app.conf", true},
+		{"/default/", "default/app.conf", true},
+	}
+	for _, table := range paths {
+		if got := (templateACL{Path: table.aclpath}).appliesTo(table.tpath); got != table.applies {
+			t.Errorf("appliesTo(%v) of '%v' was incorrect, got: '%v', want: '%v'\n", table.tpath, table.aclpath, got, table.applies)
+		}
+	}
+}
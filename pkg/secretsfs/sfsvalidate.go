@@ -0,0 +1,173 @@
+package secretsfs
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/ini.v1"
+	"gopkg.in/yaml.v3"
+)
+
+// outputValidators map the output formats, which may be declared in the
+// front matter, to functions validating rendered content
+var outputValidators = map[string]func(content []byte) error{
+	"json": func(content []byte) error {
+		var v interface{}
+		return json.Unmarshal(content, &v)
+	},
+	"yaml": func(content []byte) error {
+		var v interface{}
+		return yaml.Unmarshal(content, &v)
+	},
+	"toml": func(content []byte) error {
+		var v map[string]interface{}
+		return toml.Unmarshal(content, &v)
+	},
+	"ini": func(content []byte) error {
+		_, err := ini.Load(content)
+		return err
+	},
+	"xml": validateXML,
+}
+
+// outputValidationError is returned, if the rendered content of a
+// templatefile isn't valid in its declared format. The error of the parser
+// may contain parts of the content, i.e. values of secrets, so only the line
+// of the content it occurred in is kept.
+type outputValidationError struct {
+	format string
+	// line is the line of the rendered content, 0 if unknown
+	line int
+}
+
+func (e *outputValidationError) Error() string {
+	return fmt.Sprintf("msg=\"rendered output is no valid %s\" line=\"%d\"\n", e.format, e.line)
+}
+
+// validateOutput checks whether content is valid in format, an empty format
+// isn't validated
+func validateOutput(format string, content []byte) error {
+	if format == "" {
+		return nil
+	}
+	validate, ok := outputValidators[format]
+	if !ok {
+		return fmt.Errorf("msg=\"unknown output format\" format=\"%v\"\n", format)
+	}
+	if err := validate(content); err != nil {
+		return &outputValidationError{format: format, line: validationErrorLine(content, err)}
+	}
+	return nil
+}
+
+// yamlErrorLine matches the line in errors of the yaml parser, e.g.
+// yaml: line 3: did not find expected key
+var yamlErrorLine = regexp.MustCompile(`^yaml: line (\d+):`)
+
+// validationErrorLine returns the line of content, in which the parser
+// failed with err, or 0 if the parser doesn't tell
+func validationErrorLine(content []byte, err error) int {
+	var jerr *json.SyntaxError
+	var xerr *xml.SyntaxError
+	var terr *toml.DecodeError
+	switch {
+	case errors.As(err, &jerr):
+		offset := int(jerr.Offset)
+		if offset > len(content) {
+			offset = len(content)
+		}
+		return bytes.Count(content[:offset], []byte("\n")) + 1
+	case errors.As(err, &xerr):
+		return xerr.Line
+	case errors.As(err, &terr):
+		row, _ := terr.Position()
+		return row
+	}
+	if m := yamlErrorLine.FindStringSubmatch(err.Error()); m != nil {
+		line, _ := strconv.Atoi(m[1])
+		return line
+	}
+	return 0
+}
+
+// validateXML checks whether content is a well-formed XML document
+func validateXML(content []byte) error {
+	d := xml.NewDecoder(bytes.NewReader(content))
+	roots, depth := 0, 0
+	for {
+		t, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		switch t.(type) {
+		case xml.StartElement:
+			if depth == 0 {
+				roots++
+			}
+			depth++
+		case xml.EndElement:
+			depth--
+		}
+	}
+	if roots != 1 {
+		return fmt.Errorf("document must contain exactly one root element, found %d", roots)
+	}
+	return nil
+}
+
+// lastKnownGood contains the last valid output per user and templatefile,
+// which is served instead of invalid output if
+// fio.templatefiles.lastknowngood is set. Outputs expire after
+// fio.templatefiles.lastknowngoodttl, so that secrets aren't kept in memory
+// longer than needed.
+type lastKnownGood struct {
+	mu       sync.Mutex
+	contents map[renderErrorKey]*lastKnownGoodOutput
+}
+
+// lastKnownGoodOutput is a valid output kept by lastKnownGood
+type lastKnownGoodOutput struct {
+	content []byte
+	expires time.Time
+}
+
+var templateLastKnownGood = &lastKnownGood{contents: make(map[renderErrorKey]*lastKnownGoodOutput)}
+
+// set keeps content for key until now+ttl, expired outputs are removed
+func (l *lastKnownGood) set(key renderErrorKey, content []byte, now time.Time, ttl time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for k, o := range l.contents {
+		if !now.Before(o.expires) {
+			delete(l.contents, k)
+		}
+	}
+	if ttl <= 0 {
+		delete(l.contents, key)
+		return
+	}
+	l.contents[key] = &lastKnownGoodOutput{content: content, expires: now.Add(ttl)}
+}
+
+// get returns the output kept for key, if it didn't expire until now
+func (l *lastKnownGood) get(key renderErrorKey, now time.Time) ([]byte, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	o, ok := l.contents[key]
+	if !ok || !now.Before(o.expires) {
+		return nil, false
+	}
+	return o.content, true
+}
@@ -0,0 +1,75 @@
+package secretsfs
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateOutput(t *testing.T) {
+	tables := []struct {
+		format  string
+		content string
+		valid   bool
+		line    int
+	}{
+		{"", "anything: goes: here", true, 0},
+		{"json", `{"password": "a:b"}`, true, 0},
+		{"json", "{\n\"password\": a:b}", false, 2},
+		{"yaml", `{"password": "a:b"}`, true, 0},
+		{"yaml", `{"password": "a:b"`, false, -1},
+		{"toml", "[db]\npassword = \"a:b\"\n", true, 0},
+		{"toml", "[db]\npassword = a:b\n", false, 2},
+		{"ini", "[db]\npassword = a:b\n", true, 0},
+		{"ini", "[db\npassword\n", false, -1},
+		{"xml", "<db><password>a:b</password></db>", true, 0},
+		{"xml", "<db>\n<password>a<b</password></db>", false, 2},
+		{"xml", "<db/><db/>", false, 0},
+	}
+	for _, table := range tables {
+		err := validateOutput(table.format, []byte(table.content))
+		if (err == nil) != table.valid {
+			t.Errorf("validation of %v '%v' was incorrect, got: '%v', want valid: '%v'\n", table.format, table.content, err, table.valid)
+		}
+		if err == nil {
+			continue
+		}
+		var verr *outputValidationError
+		if !errors.As(err, &verr) {
+			t.Errorf("validation error of %v '%v' has wrong type, got: '%T'\n", table.format, table.content, err)
+			continue
+		}
+		// the error may be logged, so it must not contain the content
+		if strings.Contains(err.Error(), "a:b") || strings.Contains(err.Error(), "a<b") {
+			t.Errorf("validation error of %v '%v' contains the content, got: '%v'\n", table.format, table.content, err)
+		}
+		if table.line >= 0 && verr.line != table.line {
+			t.Errorf("line of validation error of %v '%v' was incorrect, got: '%v', want: '%v'\n", table.format, table.content, verr.line, table.line)
+		}
+	}
+	if err := validateOutput("csv", []byte("a,b")); err == nil {
+		t.Errorf("expected error for unknown format\n")
+	}
+	if got := string(redactRenderError("default/app.yaml", "app.yaml", &outputValidationError{format: "yaml", line: 3})); got != "default/app.yaml: rendered output is no valid yaml in line 3\n" {
+		t.Errorf("redacted validation error was incorrect, got: '%v'\n", got)
+	}
+}
+
+func TestLastKnownGood(t *testing.T) {
+	l := &lastKnownGood{contents: make(map[renderErrorKey]*lastKnownGoodOutput)}
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	app := renderErrorKey{uid: 1000, tpath: "/etc/secretsfs/templates/app.yaml"}
+	db := renderErrorKey{uid: 1000, tpath: "/etc/secretsfs/templates/db.yaml"}
+	l.set(app, []byte("password: a"), now, time.Hour)
+	if got, ok := l.get(app, now.Add(time.Minute)); !ok || string(got) != "password: a" {
+		t.Errorf("last known good output was incorrect, got: '%v', want: '%v'\n", string(got), "password: a")
+	}
+	if _, ok := l.get(app, now.Add(time.Hour)); ok {
+		t.Errorf("expired last known good output was served\n")
+	}
+	l.set(db, []byte("password: b"), now.Add(2*time.Hour), time.Hour)
+	if _, ok := l.contents[app]; ok {
+		t.Errorf("expired last known good output was not removed\n")
+	}
+}
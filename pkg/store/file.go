@@ -0,0 +1,132 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+
+	sfsfh "github.com/muryoutaisuu/secretsfs/pkg/fusehelpers"
+)
+
+// FileStore reads secrets from a local directory tree.
+// Directories are mapped to paths, regular files to keys with the content of
+// the file as value.
+// The calling user must be allowed to read the files according to their unix
+// permissions, as secretsfs itself generally runs as root. Symlinks below the
+// root directory are never followed.
+type FileStore struct {
+	// Root is the directory containing the secrets. If empty, store.file.path
+	// is used.
+	// $HOME will be substituted with the calling user's home directory.
+	Root string
+}
+
+var _ = (Store)((*FileStore)(nil))
+
+func (s *FileStore) GetSecret(spath string, ctx context.Context) (*Secret, error) {
+	u, err := sfsfh.GetUserFromContext(ctx)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"spath": spath,
+			"error": err}).Error("got error while getting user from context")
+		return nil, err
+	}
+	log.WithFields(log.Fields{
+		"spath":    spath,
+		"root":     s.root(u),
+		"username": u.Username}).Info("User accessing a secret")
+
+	f, err := openForUser(s.root(u), spath, u)
+	if errors.Is(err, syscall.ENOENT) || errors.Is(err, syscall.ENOTDIR) {
+		return nil, fmt.Errorf("msg=\"%w\" spath=\"%v\" error=\"%v\"\n", ErrNotFound, spath, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fileinfo, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	if fileinfo.IsDir() {
+		sec := &Secret{
+			Path: spath,
+			Mode: sfsfh.DIRREAD,
+		}
+		files, err := f.Readdir(-1)
+		if err != nil {
+			return nil, err
+		}
+		sort.Slice(files, func(i, j int) bool { return files[i].Name() < files[j].Name() })
+		for _, f := range files {
+			newsec := &Secret{
+				Path: filepath.Join(spath, f.Name()),
+			}
+			// symlinks are skipped, as they are never followed
+			switch {
+			case f.IsDir():
+				newsec.Mode = sfsfh.DIRREAD
+			case f.Mode().IsRegular():
+				newsec.Mode = sfsfh.FILEREAD
+			default:
+				continue
+			}
+			sec.Subs = append(sec.Subs, newsec)
+		}
+		return sec, nil
+	}
+
+	if !fileinfo.Mode().IsRegular() {
+		return nil, fmt.Errorf("msg=\"not a regular file\" fpath=\"%v\"\n", f.Name())
+	}
+	content, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	return &Secret{
+		Path:    spath,
+		Mode:    sfsfh.FILEREAD,
+		Content: content,
+	}, nil
+}
+
+func (s *FileStore) String() string {
+	return "file"
+}
+
+// root returns the finalized root directory for user u
+func (s *FileStore) root(u *user.User) string {
+	root := s.Root
+	if root == "" {
+		root = viper.GetString("store.file.path")
+	}
+	return strings.Replace(root, "$HOME", u.HomeDir, 1)
+}
+
+// openForUser opens spath below root on behalf of user u, as secretsfs itself
+// runs as root. Symlinks in root are resolved first, symlinks below root are
+// never followed, see sfsfh.OpenForUser.
+func openForUser(root, spath string, u *user.User) (*os.File, error) {
+	realroot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return nil, err
+	}
+	// clean spath against "/" so that it can't escape the root directory
+	return sfsfh.OpenForUser(filepath.Join(realroot, filepath.Clean(string(filepath.Separator)+spath)), u)
+}
+
+func init() {
+	f := FileStore{}
+	RegisterStore(&f)
+}
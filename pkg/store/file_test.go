@@ -0,0 +1,68 @@
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"testing"
+)
+
+func TestOpenForUser(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("needs to run as root like secretsfs, to access files of other users")
+	}
+	tmp, err := ioutil.TempDir("", "secretsfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	// alice's secrets next to a private directory of another user
+	mkdir := func(path string, perm os.FileMode) {
+		if err := os.Mkdir(path, perm); err != nil {
+			t.Fatal(err)
+		}
+		os.Chmod(path, perm)
+	}
+	os.Chmod(tmp, 0755)
+	root := filepath.Join(tmp, "alice")
+	private := filepath.Join(tmp, "private")
+	mkdir(root, 0755)
+	mkdir(filepath.Join(root, "app"), 0755)
+	mkdir(private, 0700)
+	for _, f := range []string{filepath.Join(root, "app", "password"), filepath.Join(private, "password")} {
+		if err := ioutil.WriteFile(f, []byte("secret"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(filepath.Join(private, "password"), filepath.Join(root, "app", "stolen")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(private, filepath.Join(tmp, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	alice := &user.User{Uid: "65534", Gid: "65534", Username: "alice"}
+	tables := []struct {
+		root  string
+		spath string
+		ok    bool
+	}{
+		{root, "app/password", true},
+		{root, "app", true},
+		{root, "../private/password", false},
+		// symlinks below the root are never followed
+		{root, "app/stolen", false},
+		// the private directory may not be searched by alice
+		{filepath.Join(tmp, "link"), "password", false},
+	}
+	for _, table := range tables {
+		f, err := openForUser(table.root, table.spath, alice)
+		if (err == nil) != table.ok {
+			t.Errorf("access to '%v' below '%v' was incorrect, got error: '%v', want access: '%v'\n", table.spath, table.root, err, table.ok)
+		}
+		if f != nil {
+			f.Close()
+		}
+	}
+}
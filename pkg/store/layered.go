@@ -0,0 +1,132 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+
+	sfsfh "github.com/muryoutaisuu/secretsfs/pkg/fusehelpers"
+)
+
+// Layered queries an ordered list of stores, configured in
+// store.layered.layers.
+// Listings of all layers are merged, while for keys the first layer
+// containing the key wins. This allows for shadowing single secrets of later
+// layers, e.g. with a local file store in front of vault.
+type Layered struct {
+	once   sync.Once
+	layers []Store
+}
+
+// layerConfig describes a single entry in store.layered.layers
+type layerConfig struct {
+	// Store is the name of a registered store
+	Store string
+	// Path is the root directory, only used by the file store
+	Path string
+}
+
+var _ = (Store)((*Layered)(nil))
+
+// NewLayered returns a Layered store querying layers instead of the layers
+// configured in store.layered.layers
+func NewLayered(layers ...Store) *Layered {
+	s := &Layered{layers: layers}
+	s.once.Do(func() {})
+	return s
+}
+
+func (s *Layered) GetSecret(spath string, ctx context.Context) (*Secret, error) {
+	var merged *Secret
+	var lasterr error
+	seen := make(map[string]bool)
+	for _, l := range s.getLayers() {
+		sec, err := l.GetSecret(spath, ctx)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"spath": spath,
+				"layer": l.String(),
+				"error": err}).Debug("layer could not deliver secret, continuing with next layer")
+			// errors of failing layers take precedence over missing secrets
+			if lasterr == nil || errors.Is(lasterr, ErrNotFound) {
+				lasterr = err
+			}
+			continue
+		}
+		if merged == nil {
+			// first hit wins for keys
+			if !sfsfh.IsDir(sec.Mode) {
+				return sec, nil
+			}
+			merged = &Secret{
+				Path: spath,
+				Mode: sec.Mode,
+			}
+		} else if !sfsfh.IsDir(sec.Mode) {
+			// key is shadowed by a directory of a former layer
+			continue
+		}
+		for _, sub := range sec.Subs {
+			if seen[sub.Path] {
+				continue
+			}
+			seen[sub.Path] = true
+			merged.Subs = append(merged.Subs, sub)
+		}
+	}
+	if merged == nil {
+		if lasterr == nil {
+			lasterr = fmt.Errorf("msg=\"no layers configured for layered store\" spath=\"%v\"\n", spath)
+		}
+		return nil, lasterr
+	}
+	return merged, nil
+}
+
+func (s *Layered) String() string {
+	return "layered"
+}
+
+// getLayers returns the configured layers. They are only loaded on first
+// use, so that all stores had the chance to register themselves.
+func (s *Layered) getLayers() []Store {
+	s.once.Do(func() {
+		var confs []layerConfig
+		if err := viper.UnmarshalKey("store.layered.layers", &confs); err != nil {
+			log.WithFields(log.Fields{"error": err}).Error("could not parse store.layered.layers")
+			return
+		}
+		s.layers = newLayers(confs)
+	})
+	return s.layers
+}
+
+// newLayers creates the stores described by confs
+func newLayers(confs []layerConfig) []Store {
+	var layers []Store
+	for _, c := range confs {
+		switch c.Store {
+		case "file":
+			layers = append(layers, &FileStore{Root: c.Path})
+		case "layered":
+			log.WithFields(log.Fields{"layer": c}).Error("layered store can not be used as a layer of itself, skipping")
+		default:
+			l := LookupStore(c.Store)
+			if l == nil {
+				log.WithFields(log.Fields{"layer": c}).Error("no store registered with this name, skipping")
+				continue
+			}
+			layers = append(layers, l)
+		}
+	}
+	return layers
+}
+
+func init() {
+	l := Layered{}
+	RegisterStore(&l)
+}
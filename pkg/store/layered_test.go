@@ -0,0 +1,81 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	sfsfh "github.com/muryoutaisuu/secretsfs/pkg/fusehelpers"
+)
+
+func TestLayeredGetSecret(t *testing.T) {
+	override := Map{
+		"app": {Path: "app", Mode: sfsfh.DIRREAD, Subs: []*Secret{
+			{Path: "app/password", Mode: sfsfh.FILEREAD},
+		}},
+		"app/password": {Path: "app/password", Mode: sfsfh.FILEREAD, Content: []byte("local")},
+	}
+	remote := Map{
+		"app": {Path: "app", Mode: sfsfh.DIRREAD, Subs: []*Secret{
+			{Path: "app/password", Mode: sfsfh.FILEREAD},
+			{Path: "app/username", Mode: sfsfh.FILEREAD},
+		}},
+		"app/password": {Path: "app/password", Mode: sfsfh.FILEREAD, Content: []byte("remote")},
+		"app/username": {Path: "app/username", Mode: sfsfh.FILEREAD, Content: []byte("admin")},
+	}
+	l := &Layered{layers: []Store{override, remote}}
+	l.once.Do(func() {})
+
+	tables := []struct {
+		spath   string
+		content string
+		subs    []string
+	}{
+		{"app", "", []string{"app/password", "app/username"}},
+		{"app/password", "local", nil},
+		{"app/username", "admin", nil},
+	}
+	for _, table := range tables {
+		sec, err := l.GetSecret(table.spath, nil)
+		if err != nil {
+			t.Errorf("got error for spath='%v': %v\n", table.spath, err)
+			continue
+		}
+		if string(sec.Content) != table.content {
+			t.Errorf("content of '%v' was incorrect, got: '%v', want: '%v'\n", table.spath, sec.Content, table.content)
+		}
+		if len(sec.Subs) != len(table.subs) {
+			t.Errorf("Not the correct amount of subs returned for spath='%v'!\nGot:  %v\nWant: %v\n", table.spath, sec.Subs, table.subs)
+			continue
+		}
+		for k := range sec.Subs {
+			if sec.Subs[k].Path != table.subs[k] {
+				t.Errorf("sub of '%v' was incorrect, got: '%v', want: '%v'\n", table.spath, sec.Subs[k].Path, table.subs[k])
+			}
+		}
+	}
+
+	if _, err := l.GetSecret("missing", nil); !errors.Is(err, ErrNotFound) {
+		t.Errorf("error for missing secret was incorrect, got: '%v', want: '%v'\n", err, ErrNotFound)
+	}
+
+	// errors of failing layers aren't hidden by layers missing the secret
+	failing := &Layered{layers: []Store{override, failingStore{}, remote}}
+	failing.once.Do(func() {})
+	if _, err := failing.GetSecret("missing", nil); err == nil || errors.Is(err, ErrNotFound) {
+		t.Errorf("error of failing layer was incorrect, got: '%v', want: '%v'\n", err, errUnavailable)
+	}
+}
+
+var errUnavailable = errors.New("store unavailable")
+
+// failingStore fails like an unreachable store
+type failingStore struct{}
+
+func (failingStore) GetSecret(spath string, ctx context.Context) (*Secret, error) {
+	return nil, errUnavailable
+}
+
+func (failingStore) String() string {
+	return "failing"
+}
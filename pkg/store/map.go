@@ -0,0 +1,23 @@
+package store
+
+import (
+	"context"
+	"fmt"
+)
+
+// Map is a Store containing predefined secrets mapped to their paths, e.g. for
+// tests of code reading from stores.
+type Map map[string]*Secret
+
+var _ = (Store)((Map)(nil))
+
+func (m Map) GetSecret(spath string, ctx context.Context) (*Secret, error) {
+	if s, ok := m[spath]; ok {
+		return s, nil
+	}
+	return nil, fmt.Errorf("msg=\"%w\" spath=\"%v\"\n", ErrNotFound, spath)
+}
+
+func (m Map) String() string {
+	return "map"
+}
@@ -0,0 +1,46 @@
+package store
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+)
+
+// Prefixed shows the subtree at Prefix of Store as a store of its own, e.g.
+// to use different paths of the same store as layers.
+type Prefixed struct {
+	Store  Store
+	Prefix string
+}
+
+var _ = (Store)((*Prefixed)(nil))
+
+func (s *Prefixed) GetSecret(spath string, ctx context.Context) (*Secret, error) {
+	sec, err := s.Store.GetSecret(filepath.Join(s.Prefix, spath), ctx)
+	if err != nil {
+		return nil, err
+	}
+	stripped := *sec
+	stripped.Path = s.strip(sec.Path)
+	stripped.Subs = make([]*Secret, 0, len(sec.Subs))
+	for _, sub := range sec.Subs {
+		ssub := *sub
+		ssub.Path = s.strip(sub.Path)
+		stripped.Subs = append(stripped.Subs, &ssub)
+	}
+	return &stripped, nil
+}
+
+func (s *Prefixed) String() string {
+	return s.Store.String() + ":" + s.Prefix
+}
+
+// strip removes the prefix from spath
+func (s *Prefixed) strip(spath string) string {
+	prefix := filepath.Clean(s.Prefix)
+	spath = filepath.Clean(spath)
+	if spath == prefix {
+		return ""
+	}
+	return strings.TrimPrefix(spath, prefix+string(filepath.Separator))
+}
@@ -0,0 +1,61 @@
+package store
+
+import (
+	"reflect"
+	"testing"
+
+	sfsfh "github.com/muryoutaisuu/secretsfs/pkg/fusehelpers"
+)
+
+func TestPrefixedOverlay(t *testing.T) {
+	m := Map{
+		"common/app": {Path: "common/app", Mode: sfsfh.DIRREAD, Subs: []*Secret{
+			{Path: "common/app/password", Mode: sfsfh.FILEREAD},
+			{Path: "common/app/loglevel", Mode: sfsfh.FILEREAD},
+		}},
+		"common/app/password": {Path: "common/app/password", Mode: sfsfh.FILEREAD, Content: []byte("common")},
+		"common/app/loglevel": {Path: "common/app/loglevel", Mode: sfsfh.FILEREAD, Content: []byte("info")},
+		"prod/eu/app": {Path: "prod/eu/app", Mode: sfsfh.DIRREAD, Subs: []*Secret{
+			{Path: "prod/eu/app/password", Mode: sfsfh.FILEREAD},
+			{Path: "prod/eu/app/region", Mode: sfsfh.FILEREAD},
+		}},
+		"prod/eu/app/password": {Path: "prod/eu/app/password", Mode: sfsfh.FILEREAD, Content: []byte("eu")},
+		"prod/eu/app/region":   {Path: "prod/eu/app/region", Mode: sfsfh.FILEREAD, Content: []byte("eu-west")},
+	}
+	// later layers win, so they come first in the layered store
+	l := NewLayered(&Prefixed{Store: m, Prefix: "prod/eu"}, &Prefixed{Store: m, Prefix: "prod"}, &Prefixed{Store: m, Prefix: "common"})
+
+	tables := []struct {
+		spath   string
+		content string
+		subs    []string
+	}{
+		{"app", "", []string{"app/password", "app/region", "app/loglevel"}},
+		{"app/password", "eu", nil},
+		{"app/loglevel", "info", nil},
+		{"app/region", "eu-west", nil},
+	}
+	for _, table := range tables {
+		sec, err := l.GetSecret(table.spath, nil)
+		if err != nil {
+			t.Errorf("got error for spath='%v': %v\n", table.spath, err)
+			continue
+		}
+		if sec.Path != table.spath {
+			t.Errorf("path of '%v' was incorrect, got: '%v', want: '%v'\n", table.spath, sec.Path, table.spath)
+		}
+		if string(sec.Content) != table.content {
+			t.Errorf("content of '%v' was incorrect, got: '%v', want: '%v'\n", table.spath, string(sec.Content), table.content)
+		}
+		var subs []string
+		for _, sub := range sec.Subs {
+			subs = append(subs, sub.Path)
+		}
+		if !reflect.DeepEqual(subs, table.subs) {
+			t.Errorf("subs of '%v' were incorrect, got: '%v', want: '%v'\n", table.spath, subs, table.subs)
+		}
+	}
+	if _, err := l.GetSecret("app/missing", nil); err == nil {
+		t.Errorf("expected error for missing secret\n")
+	}
+}
@@ -1,8 +1,30 @@
 package store
 
+import (
+	"encoding/json"
+	"fmt"
+)
+
 type Secret struct {
 	Path    string
 	Mode    int64
-	Content string
+	Content []byte
 	Subs    []*Secret
 }
+
+// ValueToString returns the string representation of a value of a secret
+// returned by vault. Strings are returned as is, nil values as empty string
+// and all other values, e.g. numbers or booleans, as their JSON encoding.
+func ValueToString(v interface{}) string {
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return t
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
@@ -2,18 +2,42 @@ package store
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
 	//"github.com/hanwen/go-fuse/v2/fs"
 	//"github.com/hanwen/go-fuse/v2/fuse"
+
+	"github.com/spf13/viper"
+
+	sfsfh "github.com/muryoutaisuu/secretsfs/pkg/fusehelpers"
 )
 
-// store contains the registered Store
+// ErrNotFound is wrapped into the errors of stores, if there is no secret at
+// the requested path, or the calling user may not see it. Other errors, e.g.
+// of an unreachable vault or a failed login, don't wrap it. Check it with
+// errors.Is.
+var ErrNotFound = errors.New("secret not found")
+
+// store contains the Store selected by store.enabled
 var store Store
 
+// selectOnce selects store on first use, after the configuration was read
+var selectOnce sync.Once
+
 // available stores
-var stores []string
+var stores []string = []string{}
 
-// GetStore returns currently active Store Implementation
+// registered contains all registered stores mapped to their names
+var registered map[string]Store = make(map[string]Store)
+
+// GetStore returns currently active Store Implementation.
+// It is selected by store.enabled on the first call, so that the selection
+// doesn't depend on whether the configuration was read before the stores
+// registered themselves.
 func GetStore() *Store {
+	selectOnce.Do(selectStore)
 	return &store
 }
 
@@ -24,17 +48,27 @@ func GetStores() []string {
 	return stores
 }
 
+// LookupStore returns the registered store with the given name or nil, if no
+// such store is registered.
+func LookupStore(name string) Store {
+	return registered[name]
+}
+
 // RegisterStore registers available stores
-// if a store is also set to be the backend store it will be set here
 func RegisterStore(s Store) {
 	stores = append(stores, s.String())
-	// TODO: make store configurable
-	if s.String() == "vault_kv" {
+	registered[s.String()] = s
+}
+
+// selectStore sets store to the registered store configured in
+// store.enabled. vault_kv is used as fallback, if store.enabled matches no
+// registered store.
+func selectStore() {
+	if s := LookupStore(viper.GetString("store.enabled")); s != nil {
 		store = s
+		return
 	}
-	//if viper.GetString("store.enabled") == s.String() {
-	//	store = s
-	//}
+	store = LookupStore("vault_kv")
 }
 
 // Store interface describes functions a new store should implement.
@@ -46,6 +80,35 @@ type Store interface {
 	String() string
 }
 
-func init() {
-	stores = []string{}
+// DataStore may be implemented additionally by stores, that are able to
+// return all keys of a secret at once.
+type DataStore interface {
+	GetSecretData(spath string, ctx context.Context) (data map[string]interface{}, err error)
+}
+
+// GetSecretData returns all keys of the secret at spath mapped to their
+// values. If s doesn't implement DataStore, all keys are read one by one.
+func GetSecretData(s Store, spath string, ctx context.Context) (map[string]interface{}, error) {
+	if ds, ok := s.(DataStore); ok {
+		return ds.GetSecretData(spath, ctx)
+	}
+	sec, err := s.GetSecret(spath, ctx)
+	if err != nil {
+		return nil, err
+	}
+	data := make(map[string]interface{})
+	for _, sub := range sec.Subs {
+		if !sfsfh.IsFile(sub.Mode) {
+			continue
+		}
+		key, err := s.GetSecret(sub.Path, ctx)
+		if err != nil {
+			return nil, err
+		}
+		data[filepath.Base(sub.Path)] = string(key.Content)
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("msg=\"secret contains no keys\" spath=\"%v\"\n", spath)
+	}
+	return data, nil
 }
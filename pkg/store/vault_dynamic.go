@@ -0,0 +1,143 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	log "github.com/sirupsen/logrus"
+)
+
+// Lease describes a dynamic secret issued by vault, e.g. credentials of the
+// database, aws or rabbitmq secrets engines.
+type Lease struct {
+	ID        string
+	Path      string
+	Data      map[string]interface{}
+	Renewable bool
+
+	mu       sync.Mutex
+	duration time.Duration
+	expires  time.Time
+	// client is the client of the user the lease was issued for. It is needed
+	// for renewing and revoking the lease.
+	client *api.Client
+}
+
+// ReadLease reads spath with the vault token of the calling user and returns
+// the issued lease.
+// In contrast to GetSecret, spath is not prefixed with KVMountPath.
+func ReadLease(spath string, ctx context.Context) (*Lease, error) {
+	c, err := GetVaultClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s, err := c.Logical().Read(spath)
+	if err != nil {
+		return nil, err
+	}
+	if s == nil {
+		return nil, fmt.Errorf("msg=\"no secret returned\" spath=\"%v\"\n", spath)
+	}
+	d := time.Duration(s.LeaseDuration) * time.Second
+	log.WithFields(log.Fields{
+		"spath":    spath,
+		"leaseid":  s.LeaseID,
+		"duration": d}).Info("issued lease")
+	return &Lease{
+		ID:        s.LeaseID,
+		Path:      spath,
+		Data:      s.Data,
+		Renewable: s.Renewable,
+		duration:  d,
+		expires:   time.Now().Add(d),
+		client:    c,
+	}, nil
+}
+
+// WriteVault writes data to spath with the vault token of the calling user and
+// returns the data of the response.
+// In contrast to GetSecret, spath is not prefixed with KVMountPath.
+func WriteVault(spath string, data map[string]interface{}, ctx context.Context) (map[string]interface{}, error) {
+	c, err := GetVaultClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s, err := c.Logical().Write(spath, data)
+	if err != nil {
+		return nil, err
+	}
+	if s == nil || s.Data == nil {
+		return nil, fmt.Errorf("msg=\"no data returned\" spath=\"%v\"\n", spath)
+	}
+	return s.Data, nil
+}
+
+// ListVault lists spath with the vault token of the calling user.
+// In contrast to GetSecret, spath is not prefixed with KVMountPath.
+func ListVault(spath string, ctx context.Context) ([]string, error) {
+	c, err := GetVaultClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s, err := c.Logical().List(spath)
+	if err != nil {
+		return nil, err
+	}
+	if s == nil || s.Data == nil {
+		return nil, nil
+	}
+	keys, _ := s.Data["keys"].([]interface{})
+	list := make([]string, 0, len(keys))
+	for _, k := range keys {
+		list = append(list, fmt.Sprint(k))
+	}
+	return list, nil
+}
+
+// Duration returns the current lease duration
+func (l *Lease) Duration() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.duration
+}
+
+// Valid returns false, if the lease has no duration or is already expired
+func (l *Lease) Valid() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.duration > 0 && time.Now().Before(l.expires)
+}
+
+// Renew renews the lease in vault and updates its duration
+func (l *Lease) Renew() error {
+	s, err := l.client.Sys().Renew(l.ID, 0)
+	if err != nil {
+		return err
+	}
+	if s == nil {
+		return fmt.Errorf("msg=\"no lease returned on renewal\" leaseid=\"%v\"\n", l.ID)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.duration = time.Duration(s.LeaseDuration) * time.Second
+	l.expires = time.Now().Add(l.duration)
+	log.WithFields(log.Fields{
+		"spath":    l.Path,
+		"leaseid":  l.ID,
+		"duration": l.duration}).Debug("renewed lease")
+	return nil
+}
+
+// Revoke revokes the lease in vault
+func (l *Lease) Revoke() error {
+	if l.ID == "" {
+		return nil
+	}
+	log.WithFields(log.Fields{
+		"spath":   l.Path,
+		"leaseid": l.ID}).Info("revoking lease")
+	return l.client.Sys().Revoke(l.ID)
+}
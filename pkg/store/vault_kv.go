@@ -76,12 +76,9 @@ func getSecret(spath string, ctx context.Context, appendSubs bool) (*Secret, err
 					"data":          data,
 					"error":         err}).Warn("got error while getting vault secret with client and spath for adding as subs to store secret. Continuing...")
 			} else {
-				for k := range data {
-					newsec := &Secret{
-						Path: filepath.Join(spath, k),
-						Mode: sfsfh.FILEREAD,
-					}
-					s.Subs = append(s.Subs, newsec)
+				opts := getKeyOptions()
+				for k, v := range data {
+					s.Subs = append(s.Subs, newSubSecret(spath, k, v, opts))
 				}
 			}
 		}
@@ -112,21 +109,47 @@ func getSecret(spath string, ctx context.Context, appendSubs bool) (*Secret, err
 		return s, nil
 
 	case t[vh.CKey]:
-		content, err := vh.GetValueFromKey(c, KVMountPath+spath)
+		// values of keys may be structured, so read them from the secret
+		secpath := filepath.Dir(spath)
+		data, err := c.Read(KVMountPath + secpath)
 		if err != nil {
 			return nil, err
 		}
-		return &Secret{
-			Path:    spath,
-			Mode:    sfsfh.FILEREAD,
-			Content: content,
-		}, nil
+		return getStructuredSecret(secpath, data, []string{filepath.Base(spath)}, getKeyOptions())
 
 	default: // probably not enough permissions to determine type -> would probably be a directory
-		return nil, fmt.Errorf("could not evaluate filetype of %s\n", spath)
+		// or an element inside of a structured key
+		return getNestedSecret(c, spath)
 	}
 }
 
+var _ = (DataStore)((*VaultKv)(nil))
+
+func (s *VaultKv) GetSecretData(spath string, ctx context.Context) (map[string]interface{}, error) {
+	u, err := sfsfh.GetUserFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	log.WithFields(log.Fields{
+		"spath":    spath,
+		"username": u.Username}).Info("User accessing all keys of a secret")
+	c, err := GetClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	data, err := c.Read(KVMountPath + spath)
+	if isNotFound(err) {
+		return nil, fmt.Errorf("msg=\"%w\" spath=\"%v\" error=\"%v\"\n", ErrNotFound, spath, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		return nil, fmt.Errorf("msg=\"%w\" detail=\"secret contains no keys\" spath=\"%v\"\n", ErrNotFound, spath)
+	}
+	return data, nil
+}
+
 func (s *VaultKv) String() string {
 	return "vault_kv"
 }
@@ -135,6 +158,45 @@ func (s *VaultKv) String() string {
 // The context is used to detect the calling user and loading his vault
 // approleId
 func GetClient(ctx context.Context) (*pfvault.Client, error) {
+	vc, err := GetVaultClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// Get user doing the filesystem request
+	u, err := sfsfh.GetUserFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// Create new pfvault client with vault client
+	pfc, err := pfvault.New(vc, KVMountPath) //PostFinanceClient
+	if err != nil {
+		log.WithFields(log.Fields{
+			"vaultaddress":  vc.Address(),
+			"user":          u,
+			"pfvaultclient": pfc,
+			"error":         err}).Error("got error while creating new pfc")
+		return nil, err
+	}
+	if pfc == nil {
+		log.WithFields(log.Fields{
+			"vaultaddress":  vc.Address(),
+			"user":          u,
+			"pfvaultclient": pfc,
+			"KVMountPath":   KVMountPath,
+			"error":         err}).Error("pfc is unexpectedly nil, probably not enough permissions to list KVMountPath")
+		return nil, fmt.Errorf("msg=\"pfc is unexpectedly nil, probably not enough permissions to list '%v' on vault server\"\n", KVMountPath)
+	}
+	log.WithFields(log.Fields{
+		"vaultaddress":  vc.Address(),
+		"user":          u,
+		"pfvaultclient": pfc,
+		"KVMountPath":   KVMountPath}).Debug("log values")
+	return pfc, err
+}
+
+// GetVaultClient returns a vault api client, that is logged in with the
+// approleId of the user calling the filesystem operation.
+func GetVaultClient(ctx context.Context) (*api.Client, error) {
 	// Get default vault client configuration
 	conf := api.DefaultConfig()
 	a := viper.GetString("store.vault.addr")
@@ -169,33 +231,9 @@ func GetClient(ctx context.Context) (*pfvault.Client, error) {
 	if err != nil {
 		return nil, err
 	}
-	// Set accessToken and return pfvault Client
+	// Set accessToken and return vault client
 	vc.SetToken(accessToken)
-	// Create new pfvault client with vault client
-	pfc, err := pfvault.New(vc, KVMountPath) //PostFinanceClient
-	if err != nil {
-		log.WithFields(log.Fields{
-			"clientconf":    conf,
-			"user":          u,
-			"pfvaultclient": pfc,
-			"error":         err}).Error("got error while creating new pfc")
-		return nil, err
-	}
-	if pfc == nil {
-		log.WithFields(log.Fields{
-			"clientconf":    conf,
-			"user":          u,
-			"pfvaultclient": pfc,
-			"KVMountPath":   KVMountPath,
-			"error":         err}).Error("pfc is unexpectedly nil, probably not enough permissions to list KVMountPath")
-		return nil, fmt.Errorf("msg=\"pfc is unexpectedly nil, probably not enough permissions to list '%v' on vault server\"\n", KVMountPath)
-	}
-	log.WithFields(log.Fields{
-		"clientconf":    conf,
-		"user":          u,
-		"pfvaultclient": pfc,
-		"KVMountPath":   KVMountPath}).Debug("log values")
-	return pfc, err
+	return vc, nil
 }
 
 func configureTLS(c *api.Config) error {
@@ -0,0 +1,199 @@
+package store
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/spf13/viper"
+
+	sfsfh "github.com/muryoutaisuu/secretsfs/pkg/fusehelpers"
+	pfvault "github.com/postfinance/vaultkv"
+)
+
+// structured modes configurable in store.vault.structuredkeys
+const (
+	// StructuredExpand shows nested maps as directories and lists as
+	// directories with indexed entries
+	StructuredExpand = "expand"
+	// StructuredJSON shows structured keys as files with the suffix ".json"
+	// containing their JSON encoding
+	StructuredJSON = "json"
+)
+
+// keyOptions defines how keys of vault secrets are shown
+type keyOptions struct {
+	// mode is the structured mode, see store.vault.structuredkeys
+	mode string
+	// base64suffix marks keys with base64 encoded values, which are shown
+	// decoded without the suffix, see store.vault.base64suffix
+	base64suffix string
+}
+
+// getKeyOptions returns the configured keyOptions
+func getKeyOptions() keyOptions {
+	return keyOptions{
+		mode:         viper.GetString("store.vault.structuredkeys"),
+		base64suffix: viper.GetString("store.vault.base64suffix"),
+	}
+}
+
+// getNestedSecret searches the vault secret containing spath by walking up
+// spath and returns the Secret at the remaining path inside of its data.
+func getNestedSecret(c *pfvault.Client, spath string) (*Secret, error) {
+	parts := strings.Split(spath, string(filepath.Separator))
+	var lasterr error
+	for i := len(parts) - 1; i >= 1; i-- {
+		secpath := filepath.Join(parts[:i]...)
+		data, err := c.Read(KVMountPath + secpath)
+		if err != nil && !isNotFound(err) {
+			lasterr = err
+		}
+		if err != nil || data == nil {
+			continue
+		}
+		s, err := getStructuredSecret(secpath, data, parts[i:], getKeyOptions())
+		if err != nil {
+			return nil, fmt.Errorf("msg=\"%w\" error=\"%v\"\n", ErrNotFound, err)
+		}
+		return s, nil
+	}
+	// e.g. an unreachable vault, which mustn't be mistaken for a missing secret
+	if lasterr != nil {
+		return nil, lasterr
+	}
+	return nil, fmt.Errorf("msg=\"%w\" detail=\"could not find secret containing spath\" spath=\"%v\"\n", ErrNotFound, spath)
+}
+
+// isNotFound checks whether err of vault means, that the path doesn't exist
+// or may not be read with the token of the client
+func isNotFound(err error) bool {
+	var re *api.ResponseError
+	return errors.As(err, &re) && (re.StatusCode == http.StatusNotFound || re.StatusCode == http.StatusForbidden)
+}
+
+// getStructuredSecret returns the Secret at the path rest inside of data of
+// the vault secret at secpath, according to opts.
+func getStructuredSecret(secpath string, data map[string]interface{}, rest []string, opts keyOptions) (*Secret, error) {
+	if opts.mode == StructuredJSON {
+		if len(rest) != 1 {
+			return nil, fmt.Errorf("no such key %s in secret %s\n", filepath.Join(rest...), secpath)
+		}
+		k := rest[0]
+		spath := filepath.Join(secpath, k)
+		if v, ok := data[k]; ok && !isStructured(v) {
+			return &Secret{Path: spath, Mode: sfsfh.FILEREAD, Content: []byte(ValueToString(v))}, nil
+		}
+		if v, ok := lookupBase64Key(data, k, opts); ok {
+			return newBase64Secret(spath, v)
+		}
+		if v, ok := data[strings.TrimSuffix(k, ".json")]; ok && strings.HasSuffix(k, ".json") && isStructured(v) {
+			content, err := json.MarshalIndent(v, "", "  ")
+			if err != nil {
+				return nil, err
+			}
+			return &Secret{Path: spath, Mode: sfsfh.FILEREAD, Content: append(content, '\n')}, nil
+		}
+		return nil, fmt.Errorf("no such key %s in secret %s\n", k, secpath)
+	}
+
+	var value interface{} = data
+	spath := secpath
+	for i, k := range rest {
+		switch vt := value.(type) {
+		case map[string]interface{}:
+			v, ok := vt[k]
+			if !ok {
+				if v, ok := lookupBase64Key(vt, k, opts); ok && i == len(rest)-1 {
+					return newBase64Secret(filepath.Join(spath, k), v)
+				}
+				return nil, fmt.Errorf("no such key %s in %s\n", k, spath)
+			}
+			value = v
+		case []interface{}:
+			idx, err := strconv.Atoi(k)
+			if err != nil || idx < 0 || idx >= len(vt) {
+				return nil, fmt.Errorf("no such index %s in %s\n", k, spath)
+			}
+			value = vt[idx]
+		default:
+			return nil, fmt.Errorf("%s is not a directory\n", spath)
+		}
+		spath = filepath.Join(spath, k)
+	}
+
+	switch vt := value.(type) {
+	case map[string]interface{}:
+		s := &Secret{Path: spath, Mode: sfsfh.DIRREAD}
+		keys := make([]string, 0, len(vt))
+		for k := range vt {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			s.Subs = append(s.Subs, newSubSecret(spath, k, vt[k], opts))
+		}
+		return s, nil
+	case []interface{}:
+		s := &Secret{Path: spath, Mode: sfsfh.DIRREAD}
+		for i, v := range vt {
+			s.Subs = append(s.Subs, newSubSecret(spath, strconv.Itoa(i), v, opts))
+		}
+		return s, nil
+	default:
+		return &Secret{Path: spath, Mode: sfsfh.FILEREAD, Content: []byte(ValueToString(value))}, nil
+	}
+}
+
+// newSubSecret returns the Secret listed for key k with value v inside of
+// spath, according to opts
+func newSubSecret(spath, k string, v interface{}, opts keyOptions) *Secret {
+	_, isString := v.(string)
+	switch {
+	case isString && opts.base64suffix != "" && strings.HasSuffix(k, opts.base64suffix):
+		return &Secret{Path: filepath.Join(spath, strings.TrimSuffix(k, opts.base64suffix)), Mode: sfsfh.FILEREAD}
+	case !isStructured(v):
+		return &Secret{Path: filepath.Join(spath, k), Mode: sfsfh.FILEREAD}
+	case opts.mode == StructuredJSON:
+		return &Secret{Path: filepath.Join(spath, k+".json"), Mode: sfsfh.FILEREAD}
+	default:
+		return &Secret{Path: filepath.Join(spath, k), Mode: sfsfh.DIRREAD}
+	}
+}
+
+// lookupBase64Key returns the base64 encoded value of key k in m, which is
+// stored in m with opts.base64suffix appended to k
+func lookupBase64Key(m map[string]interface{}, k string, opts keyOptions) (string, bool) {
+	if opts.base64suffix == "" {
+		return "", false
+	}
+	v, ok := m[k+opts.base64suffix].(string)
+	return v, ok
+}
+
+// newBase64Secret returns a Secret at spath with the decoded value as content.
+// Whitespace inside of the value is ignored, as encoded binaries are often
+// wrapped over multiple lines.
+func newBase64Secret(spath, value string) (*Secret, error) {
+	content, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(value), ""))
+	if err != nil {
+		return nil, fmt.Errorf("could not decode base64 value of %s: %v\n", spath, err)
+	}
+	return &Secret{Path: spath, Mode: sfsfh.FILEREAD, Content: content}, nil
+}
+
+// isStructured checks whether v is a nested map or a list
+func isStructured(v interface{}) bool {
+	switch v.(type) {
+	case map[string]interface{}, []interface{}:
+		return true
+	}
+	return false
+}
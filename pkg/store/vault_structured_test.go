@@ -0,0 +1,79 @@
+package store
+
+import (
+	"strings"
+	"testing"
+
+	sfsfh "github.com/muryoutaisuu/secretsfs/pkg/fusehelpers"
+)
+
+func TestGetStructuredSecret(t *testing.T) {
+	data := map[string]interface{}{
+		"password":     "secret",
+		"keystore.b64": "AAEC\n/w==",
+		"port":         float64(5432),
+		"config": map[string]interface{}{
+			"host": "db.example.com",
+			"tls":  true,
+		},
+		"hosts": []interface{}{"a", map[string]interface{}{"name": "b"}},
+	}
+	expand := keyOptions{mode: StructuredExpand, base64suffix: ".b64"}
+	asjson := keyOptions{mode: StructuredJSON, base64suffix: ".b64"}
+	tables := []struct {
+		opts    keyOptions
+		rest    string
+		isdir   bool
+		content string
+		subs    []string
+	}{
+		{expand, "password", false, "secret", nil},
+		{expand, "port", false, "5432", nil},
+		{expand, "config", true, "", []string{"app/config/host", "app/config/tls"}},
+		{expand, "config/tls", false, "true", nil},
+		{expand, "hosts", true, "", []string{"app/hosts/0", "app/hosts/1"}},
+		{expand, "hosts/1/name", false, "b", nil},
+		{expand, "keystore", false, "\x00\x01\x02\xff", nil},
+		{expand, "keystore.b64", false, "AAEC\n/w==", nil},
+		{asjson, "password", false, "secret", nil},
+		{asjson, "keystore", false, "\x00\x01\x02\xff", nil},
+		{asjson, "config.json", false, "{\n  \"host\": \"db.example.com\",\n  \"tls\": true\n}\n", nil},
+	}
+	for _, table := range tables {
+		sec, err := getStructuredSecret("app", data, strings.Split(table.rest, "/"), table.opts)
+		if err != nil {
+			t.Errorf("got error for rest='%v' opts='%v': %v\n", table.rest, table.opts, err)
+			continue
+		}
+		if sfsfh.IsDir(sec.Mode) != table.isdir {
+			t.Errorf("type of '%v' was incorrect, got dir: '%v', want dir: '%v'\n", table.rest, sfsfh.IsDir(sec.Mode), table.isdir)
+		}
+		if string(sec.Content) != table.content {
+			t.Errorf("content of '%v' was incorrect, got: '%v', want: '%v'\n", table.rest, sec.Content, table.content)
+		}
+		if len(sec.Subs) != len(table.subs) {
+			t.Errorf("Not the correct amount of subs returned for rest='%v'!\nGot:  %v\nWant: %v\n", table.rest, sec.Subs, table.subs)
+			continue
+		}
+		for k := range sec.Subs {
+			if sec.Subs[k].Path != table.subs[k] {
+				t.Errorf("sub of '%v' was incorrect, got: '%v', want: '%v'\n", table.rest, sec.Subs[k].Path, table.subs[k])
+			}
+		}
+	}
+
+	for _, rest := range []string{"missing", "hosts/2", "password/foo", "keystore/foo"} {
+		if _, err := getStructuredSecret("app", data, strings.Split(rest, "/"), expand); err == nil {
+			t.Errorf("expected error for rest='%v'\n", rest)
+		}
+	}
+	sub := newSubSecret("app", "keystore.b64", data["keystore.b64"], expand)
+	if sub.Path != "app/keystore" {
+		t.Errorf("path of base64 encoded key was incorrect, got: '%v', want: '%v'\n", sub.Path, "app/keystore")
+	}
+	for _, rest := range []string{"config", "config/host", "password.json"} {
+		if _, err := getStructuredSecret("app", data, strings.Split(rest, "/"), asjson); err == nil {
+			t.Errorf("expected error in json mode for rest='%v'\n", rest)
+		}
+	}
+}